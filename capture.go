@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+//captureValue - a value to run through CyberSaucier, along with the column(s) it came from
+type captureValue struct {
+	Column string
+	Value  string
+}
+
+//resolveColumn - finds a column by header name first, then by zero based index; returns -1 if not found
+func resolveColumn(name string, headers []string) int {
+	for i, header := range headers {
+		if header == name {
+			return i
+		}
+	}
+	if idx, err := strconv.Atoi(name); err == nil && idx >= 0 {
+		return idx
+	}
+	return -1
+}
+
+func columnLabel(idx int, headers []string) string {
+	if idx < len(headers) && headers[idx] != "" {
+		return headers[idx]
+	}
+	return strconv.Itoa(idx)
+}
+
+//getCaptures - pulls the capture value(s) out of a record
+func getCaptures(prof *profileConfig, headers []string, record []string) []captureValue {
+	opts := prof.CSVOptions
+	numRecords := len(record)
+
+	if len(opts.CaptureColumns) == 0 {
+		if opts.CaptureColumn < numRecords {
+			return []captureValue{{Column: columnLabel(opts.CaptureColumn, headers), Value: record[opts.CaptureColumn]}}
+		}
+		return []captureValue{{Column: "", Value: strings.Join(record, ",")}}
+	}
+
+	captures := make([]captureValue, 0, len(opts.CaptureColumns))
+	for _, name := range opts.CaptureColumns {
+		idx := resolveColumn(name, headers)
+		if idx < 0 || idx >= numRecords {
+			continue
+		}
+		captures = append(captures, captureValue{Column: columnLabel(idx, headers), Value: record[idx]})
+	}
+
+	if len(captures) == 0 {
+		return []captureValue{{Column: "", Value: strings.Join(record, ",")}}
+	}
+
+	if opts.CaptureMode == "concat" && len(captures) > 1 {
+		columns := make([]string, len(captures))
+		values := make([]string, len(captures))
+		for i, c := range captures {
+			columns[i] = c.Column
+			values[i] = c.Value
+		}
+		return []captureValue{{Column: strings.Join(columns, ","), Value: strings.Join(values, opts.CaptureSeparator)}}
+	}
+
+	return captures
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCaptures(t *testing.T) {
+	config = createDefaultConfig()
+	headers := []string{"time", "url", "user_agent"}
+	record := []string{"12:00", "http://a.b/c", "curl/7.1"}
+
+	config.CSVOptions.CaptureColumn = 1
+	assert.Equal(t, []captureValue{{Column: "url", Value: "http://a.b/c"}}, getCaptures(config.defaultProfile(), headers, record))
+
+	config.CSVOptions.CaptureColumn = 99
+	assert.Equal(t, []captureValue{{Column: "", Value: "12:00,http://a.b/c,curl/7.1"}}, getCaptures(config.defaultProfile(), headers, record))
+
+	config.CSVOptions.CaptureColumns = []string{"url", "2", "missing"}
+	assert.Equal(t, []captureValue{
+		{Column: "url", Value: "http://a.b/c"},
+		{Column: "user_agent", Value: "curl/7.1"},
+	}, getCaptures(config.defaultProfile(), headers, record))
+	assert.Equal(t, []captureValue{{Column: "2", Value: "curl/7.1"}}, getCaptures(config.defaultProfile(), nil, record))
+
+	config.CSVOptions.CaptureMode = "concat"
+	config.CSVOptions.CaptureSeparator = " | "
+	assert.Equal(t, []captureValue{{Column: "url,user_agent", Value: "http://a.b/c | curl/7.1"}}, getCaptures(config.defaultProfile(), headers, record))
+}
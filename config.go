@@ -12,8 +12,14 @@ import (
 )
 
 type csvconfig struct {
-	FirstRowHeader bool `json:"FirstRowHeader"`
-	CaptureColumn  int  `json:"CaptureColumn"`
+	FirstRowHeader   bool     `json:"FirstRowHeader"`
+	CaptureColumn    int      `json:"CaptureColumn"`
+	CaptureColumns   []string `json:"CaptureColumns"`
+	CaptureMode      string   `json:"CaptureMode"`
+	CaptureSeparator string   `json:"CaptureSeparator"`
+	Delimiter        string   `json:"Delimiter"`
+	Comment          string   `json:"Comment"`
+	Headers          []string `json:"Headers"`
 }
 type esconfig struct {
 	Enabled         bool   `json:"Enabled"`
@@ -26,17 +32,27 @@ type esconfig struct {
 	QueueSize       int    `json:"QueueSize"`
 	Sleep           int    `json:"Sleep"`
 	UseSimpleClient bool   `json:"UseSimpleClient"`
+
+	MaxRecordsPerSecond int `json:"MaxRecordsPerSecond"`
 }
 type extraparsing struct {
-	Name  string `json:"Name"`
-	Start string `json:"Start"`
-	End   string `json:"End"`
+	Name   string `json:"Name"`
+	Start  string `json:"Start"`
+	End    string `json:"End"`
+	Regex  string `json:"Regex"`
+	Match  string `json:"Match"`
+	Column string `json:"Column"`
 }
 type cybersaucierConfig struct {
 	Enabled bool   `json:"Enabled"`
 	URL     string `json:"URL"`
 	Query   string `json:"Query"`
 }
+type nativeConfig struct {
+	Enabled bool     `json:"Enabled"`
+	Mode    string   `json:"Mode"`
+	Recipes []string `json:"Recipes"`
+}
 
 type alertConfig struct {
 	Threshold int    `json:"Threshold"`
@@ -52,24 +68,50 @@ type smtpConfig struct {
 }
 
 type configuration struct {
-	Name               string             `json:"Name"`
-	IgnoreCertErrors   bool               `json:"IgnoreCertErrors"`
-	WatchFolder        string             `json:"WatchFolder"`
-	InputAlert         alertConfig        `json:"InputAlert"`
-	OutputAlert        alertConfig        `json:"OutputAlert"`
-	MaxConcurrentFiles int                `json:"MaxConcurrentFiles"`
-	DoneFolder         string             `json:"DoneFolder"`
-	MoveAfterProcessed bool               `json:"MoveAfterProcessed"`
-	IgnoreList         []string           `json:"IgnoreList"`
-	SaveNoSauce        bool               `json:"SaveNoSauce"`
-	NoSauceFile        string             `json:"NoSauceFile"`
-	ParseErrorFile     string             `json:"ParseErrorFile"`
-	WaitInterval       int                `json:"WaitInterval"`
-	CyberSaucier       cybersaucierConfig `json:"CyberSaucier"`
-	CSVOptions         csvconfig          `json:"CSVOptions"`
-	ElasticSearch      esconfig           `json:"ElasticSearch"`
-	ExtraParsing       []extraparsing     `json:"ExtraParsing"`
-	MailConfig         smtpConfig         `json:"MailConfig"`
+	Name               string                  `json:"Name"`
+	IgnoreCertErrors   bool                    `json:"IgnoreCertErrors"`
+	WatchFolder        string                  `json:"WatchFolder"`
+	WatchMode          string                  `json:"WatchMode"`
+	PollInterval       int                     `json:"PollInterval"`
+	InputAlert         alertConfig             `json:"InputAlert"`
+	OutputAlert        alertConfig             `json:"OutputAlert"`
+	MaxConcurrentFiles int                     `json:"MaxConcurrentFiles"`
+	DoneFolder         string                  `json:"DoneFolder"`
+	FailedFolder       string                  `json:"FailedFolder"`
+	Retry              retryConfig             `json:"Retry"`
+	Retention          retentionConfig         `json:"Retention"`
+	MoveAfterProcessed bool                    `json:"MoveAfterProcessed"`
+	Move               moveConfig              `json:"Move"`
+	IgnoreList         []string                `json:"IgnoreList"`
+	Selection          selectionConfig         `json:"Selection"`
+	SaveNoSauce        bool                    `json:"SaveNoSauce"`
+	NoSauceFile        string                  `json:"NoSauceFile"`
+	ParseErrorFile     string                  `json:"ParseErrorFile"`
+	WaitInterval       int                     `json:"WaitInterval"`
+	CyberSaucier       cybersaucierConfig      `json:"CyberSaucier"`
+	NativeRecipes      nativeConfig            `json:"NativeRecipes"`
+	Recipes            map[string]recipeConfig `json:"Recipes"`
+	DefaultSeverity    int                     `json:"DefaultSeverity"`
+	CSVOptions         csvconfig               `json:"CSVOptions"`
+	ElasticSearch      esconfig                `json:"ElasticSearch"`
+	ExtraParsing       []extraparsing          `json:"ExtraParsing"`
+	SubParsers         []subparserConfig       `json:"SubParsers"`
+	Schema             []fieldSchema           `json:"Schema"`
+	Profiles           []profileConfig         `json:"Profiles"`
+	GeoIP              geoipConfig             `json:"GeoIP"`
+	ThreatIntel        threatIntelConfig       `json:"ThreatIntel"`
+	ReverseDNS         reverseDNSConfig        `json:"ReverseDNS"`
+	Rules              []ruleConfig            `json:"Rules"`
+	Redaction          redactionConfig         `json:"Redaction"`
+	Dedup              dedupConfig             `json:"Dedup"`
+	Sampling           samplingConfig          `json:"Sampling"`
+	Summary            summaryConfig           `json:"Summary"`
+	Readiness          readinessConfig         `json:"Readiness"`
+	State              stateConfig             `json:"State"`
+	Tail               tailConfig              `json:"Tail"`
+	TailStateFile      string                  `json:"TailStateFile"`
+	ShutdownTimeout    int                     `json:"ShutdownTimeout"`
+	MailConfig         smtpConfig              `json:"MailConfig"`
 }
 
 func (c *configuration) doMacro(input string) string {
@@ -104,26 +146,62 @@ func createDefaultConfig() *configuration {
 		Name:             "Empty",
 		IgnoreCertErrors: false,
 		WatchFolder:      ".\\Watch",
+		WatchMode:        "fsnotify",
+		PollInterval:     10,
 		InputAlert: alertConfig{
 			Threshold: -1,
 			Email:     "",
 		},
 		DoneFolder:         ".\\Done",
+		FailedFolder:       "",
 		MaxConcurrentFiles: 3,
 		MoveAfterProcessed: true,
 		SaveNoSauce:        false,
 		NoSauceFile:        "nojuice_$date$.csv",
 		ParseErrorFile:     "parseerrors_$date$.csv",
 		WaitInterval:       30,
+		Move: moveConfig{
+			PreserveSubfolders: true,
+			Collision:          "suffix",
+		},
+		Retry: retryConfig{
+			MaxAttempts:      5,
+			Backoff:          60,
+			MaxBackoff:       3600,
+			QuarantineFolder: "",
+		},
+		Retention: retentionConfig{
+			Enabled:           false,
+			Interval:          3600,
+			DryRun:            false,
+			CompressAfterDays: 0,
+			DeleteAfterDays:   0,
+			MaxSizeMB:         0,
+			Bundle:            false,
+		},
 		CyberSaucier: cybersaucierConfig{
 			Enabled: false,
 			URL:     "",
 			Query:   "",
 		},
-		IgnoreList: make([]string, 0),
+		NativeRecipes: nativeConfig{
+			Enabled: false,
+			Mode:    "fallback",
+			Recipes: make([]string, 0),
+		},
+		Recipes:         make(map[string]recipeConfig),
+		DefaultSeverity: 1,
+		IgnoreList:      make([]string, 0),
+		Selection: selectionConfig{
+			Include: make([]fileMatcher, 0),
+			Exclude: make([]fileMatcher, 0),
+		},
 		CSVOptions: csvconfig{
-			FirstRowHeader: false,
-			CaptureColumn:  0,
+			FirstRowHeader:   false,
+			CaptureColumn:    0,
+			CaptureColumns:   make([]string, 0),
+			CaptureMode:      "separate",
+			CaptureSeparator: " ",
 		},
 		ElasticSearch: esconfig{
 			Enabled:         false,
@@ -136,6 +214,77 @@ func createDefaultConfig() *configuration {
 			UseSimpleClient: true,
 		},
 		ExtraParsing: make([]extraparsing, 0),
+		SubParsers:   make([]subparserConfig, 0),
+		Schema:       defaultSchema(),
+		Profiles:     make([]profileConfig, 0),
+		GeoIP: geoipConfig{
+			Enabled:        false,
+			Fields:         []string{"src_ip", "dest_ip"},
+			IncludeHits:    true,
+			ReloadInterval: 60,
+		},
+		ThreatIntel: threatIntelConfig{
+			Enabled:        false,
+			Lists:          make([]intelListConfig, 0),
+			ReloadInterval: 60,
+		},
+		ReverseDNS: reverseDNSConfig{
+			Enabled:       false,
+			Fields:        []string{"src_ip", "dest_ip"},
+			ForwardHits:   false,
+			Resolver:      "",
+			MaxConcurrent: 10,
+			Timeout:       2,
+			CacheTTL:      3600,
+			NegativeTTL:   300,
+			CacheSize:     100000,
+		},
+		Rules: make([]ruleConfig, 0),
+		Redaction: redactionConfig{
+			Enabled: false,
+			HMACKey: "",
+			Rules:   make([]redactionRule, 0),
+		},
+		Dedup: dedupConfig{
+			Enabled:     false,
+			Fields:      make([]string, 0),
+			Window:      3600,
+			MaxEntries:  1000000,
+			PersistFile: "",
+		},
+		Sampling: samplingConfig{
+			Enabled: false,
+			Rules:   make([]samplingRule, 0),
+		},
+		Summary: summaryConfig{
+			Enabled:           false,
+			IndexStart:        "saucepan-summary-",
+			WriteFile:         true,
+			TopHits:           10,
+			AggregateInterval: 300,
+		},
+		Readiness: readinessConfig{
+			Strategy:       "sleep",
+			PollInterval:   1,
+			StablePolls:    3,
+			QuietPeriod:    5,
+			MarkerSuffixes: []string{".done", ".ok"},
+			KeepMarker:     false,
+			TempSuffixes:   []string{".tmp", ".part", ".partial"},
+			Timeout:        3600,
+		},
+		State: stateConfig{
+			Enabled:         false,
+			File:            "saucepan_state.json",
+			CheckpointLines: 1000,
+			RetentionDays:   30,
+		},
+		Tail: tailConfig{
+			Enabled:      false,
+			PollInterval: 1,
+		},
+		TailStateFile:   "saucepan_tail.json",
+		ShutdownTimeout: 30,
 	}
 	return defaultConfig
 	//saveConfig("./config.json", defaultConfig)
@@ -178,9 +327,50 @@ func loadConfig(filepath string) {
 	//Load Environment Variable Overrides
 	getFromEnvVariables("SAUCE_", config)
 
+	validateExtraParsing(config.ExtraParsing)
+	validateSubParsers(config.SubParsers)
+	validateSchema(config.Schema)
+	for _, p := range config.Profiles {
+		if p.FileRegex != "" {
+			if _, err := getRegex(p.FileRegex); err != nil {
+				log.WithError(err).WithField("Profile", p.Name).Fatal("Invalid profile FileRegex")
+			}
+		}
+		validateExtraParsing(p.ExtraParsing)
+		validateSubParsers(p.SubParsers)
+		validateSchema(p.Schema)
+		validateReadiness(p.Readiness)
+	}
+	validateReadiness(&config.Readiness)
+	switch config.WatchMode {
+	case "", "fsnotify", "poll", "auto":
+	default:
+		log.WithField("WatchMode", config.WatchMode).Fatal("Invalid WatchMode, must be fsnotify, poll or auto")
+	}
+	switch config.Move.Collision {
+	case "", "suffix", "hash", "overwrite":
+	default:
+		log.WithField("Collision", config.Move.Collision).Fatal("Invalid Move.Collision, must be suffix, hash or overwrite")
+	}
+	validateNativeRecipes(config.NativeRecipes)
+	validateRules(config.Rules)
+	validateRedaction(config.Redaction)
+	validateSampling(config.Sampling)
+	validateSelection(config.Selection)
+
 	log.WithField("Config", config).Debug("Configuration Loaded")
 }
 
+func validateExtraParsing(extras []extraparsing) {
+	for _, extra := range extras {
+		if extra.Regex != "" {
+			if _, err := getRegex(extra.Regex); err != nil {
+				log.WithError(err).WithField("Name", extra.Name).Fatal("Invalid ExtraParsing regex")
+			}
+		}
+	}
+}
+
 ///Taken & Modified from:https://github.com/tkanos/gonfig/blob/master/gonfig.go
 func getFromEnvVariables(parent string, obj interface{}) {
 	typ := reflect.TypeOf(obj)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type dedupConfig struct {
+	Enabled     bool     `json:"Enabled"`
+	Fields      []string `json:"Fields"`
+	Window      int      `json:"Window"`
+	MaxEntries  int      `json:"MaxEntries"`
+	PersistFile string   `json:"PersistFile"`
+}
+
+//dedupEntry - a record key and when it was first seen
+type dedupEntry struct {
+	Key  string    `json:"Key"`
+	Seen time.Time `json:"Seen"`
+}
+
+//dedupWindow - the keys seen within the window, oldest first from order[head], bounded by time and count
+type dedupWindow struct {
+	lock  sync.Mutex
+	keys  map[string]bool
+	order []dedupEntry
+	head  int
+	dirty bool
+	now   func() time.Time
+}
+
+var dedup *dedupWindow
+
+func newDedupWindow() *dedupWindow {
+	return &dedupWindow{
+		keys:  make(map[string]bool),
+		order: make([]dedupEntry, 0),
+		now:   time.Now,
+	}
+}
+
+func initDedup() {
+	if !config.Dedup.Enabled {
+		return
+	}
+	dedup = newDedupWindow()
+	if config.Dedup.PersistFile != "" {
+		if err := dedup.load(config.Dedup.PersistFile); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).WithField("File", config.Dedup.PersistFile).Warn("Unable to load dedup window")
+		}
+	}
+}
+
+//dedupKey - a hash of the configured fields of the parsed record, or of the whole raw row
+func dedupKey(obj map[string]interface{}, record []string) string {
+	h := sha256.New()
+	if len(config.Dedup.Fields) > 0 {
+		for _, field := range config.Dedup.Fields {
+			h.Write([]byte(field))
+			h.Write([]byte{0})
+			h.Write([]byte(strings.Join(fieldStrings(obj[field]), "\x1f")))
+			h.Write([]byte{0})
+		}
+	} else {
+		h.Write([]byte(strings.Join(record, "\x00")))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//expire - drops keys that are older than the window or over the size limit, the lock must be held.
+//Expired entries are skipped by moving head, the slice is only compacted once they are half of it
+func (w *dedupWindow) expire() {
+	cutoff := w.now().Add(-time.Second * time.Duration(config.Dedup.Window))
+	start := w.head
+	for w.head < len(w.order) {
+		entry := w.order[w.head]
+		if (config.Dedup.Window > 0 && entry.Seen.Before(cutoff)) ||
+			(config.Dedup.MaxEntries > 0 && len(w.order)-w.head > config.Dedup.MaxEntries) {
+			delete(w.keys, entry.Key)
+			w.order[w.head] = dedupEntry{}
+			w.head++
+		} else {
+			break
+		}
+	}
+	if w.head == start {
+		return
+	}
+	w.dirty = true
+	if w.head > len(w.order)/2 {
+		w.order = append(make([]dedupEntry, 0, len(w.order)-w.head), w.order[w.head:]...)
+		w.head = 0
+	}
+}
+
+//entries - the keys in the window, oldest first, the lock must be held
+func (w *dedupWindow) entries() []dedupEntry {
+	return w.order[w.head:]
+}
+
+//isDuplicate - true if the key is already in the window, otherwise it is added
+func (w *dedupWindow) isDuplicate(key string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.expire()
+	if w.keys[key] {
+		return true
+	}
+	w.keys[key] = true
+	w.order = append(w.order, dedupEntry{Key: key, Seen: w.now()})
+	w.dirty = true
+	w.expire()
+	return false
+}
+
+func (w *dedupWindow) load(fullpath string) error {
+	data, err := ioutil.ReadFile(fullpath)
+	if err != nil {
+		return err
+	}
+	entries := make([]dedupEntry, 0)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, entry := range entries {
+		if !w.keys[entry.Key] {
+			w.keys[entry.Key] = true
+			w.order = append(w.order, entry)
+		}
+	}
+	w.expire()
+	w.dirty = false
+	log.WithFields(log.Fields{"File": fullpath, "Entries": len(w.entries())}).Info("Loaded dedup window")
+	return nil
+}
+
+//save - writes the window to disk (via a temp file, so a crash never leaves it half written)
+func (w *dedupWindow) save(fullpath string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.dirty {
+		return nil
+	}
+
+	w.expire()
+	data, err := json.Marshal(w.entries())
+	if err != nil {
+		return err
+	}
+	tmp := fullpath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fullpath); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+//forget - removes keys again, for records that never made it to the outputs
+func (w *dedupWindow) forget(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	forgotten := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if w.keys[key] {
+			forgotten[key] = true
+			delete(w.keys, key)
+		}
+	}
+	if len(forgotten) == 0 {
+		return
+	}
+	kept := make([]dedupEntry, 0, len(w.order)-w.head-len(forgotten))
+	for _, entry := range w.entries() {
+		if !forgotten[entry.Key] {
+			kept = append(kept, entry)
+		}
+	}
+	w.order = kept
+	w.head = 0
+	w.dirty = true
+}
+
+//isDuplicateRecord - checks (and remembers) the record if dedup is enabled, returning the key it was remembered by
+func isDuplicateRecord(obj map[string]interface{}, record []string) (bool, string) {
+	if !config.Dedup.Enabled || dedup == nil {
+		return false, ""
+	}
+	key := dedupKey(obj, record)
+	if dedup.isDuplicate(key) {
+		return true, ""
+	}
+	return false, key
+}
+
+//forgetDedupKeys - lets records that were remembered but not sent through the dedup window again
+func forgetDedupKeys(keys []string) {
+	if dedup != nil {
+		dedup.forget(keys)
+	}
+}
+
+//saveDedup - persists the window, if a PersistFile is configured
+func saveDedup() {
+	if dedup == nil || config.Dedup.PersistFile == "" {
+		return
+	}
+	if err := dedup.save(config.Dedup.PersistFile); err != nil {
+		log.WithError(err).WithField("File", config.Dedup.PersistFile).Warn("Unable to save dedup window")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupKey(t *testing.T) {
+	config = createDefaultConfig()
+	a := map[string]interface{}{"Line": 1, "src_ip": "10.0.0.1", "url": "http://a"}
+	b := map[string]interface{}{"Line": 9, "src_ip": "10.0.0.1", "url": "http://b"}
+	assert.NotEqual(t, dedupKey(a, []string{"10.0.0.1", "http://a"}), dedupKey(b, []string{"10.0.0.1", "http://b"}))
+	assert.Equal(t, dedupKey(a, []string{"x", "y"}), dedupKey(b, []string{"x", "y"}))
+
+	config.Dedup.Fields = []string{"src_ip"}
+	assert.Equal(t, dedupKey(a, []string{"10.0.0.1", "http://a"}), dedupKey(b, []string{"10.0.0.1", "http://b"}))
+}
+
+func TestDedupWindow(t *testing.T) {
+	config = createDefaultConfig()
+	config.Dedup.Window = 60
+	config.Dedup.MaxEntries = 2
+
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := newDedupWindow()
+	w.now = func() time.Time { return now }
+
+	assert.False(t, w.isDuplicate("a"))
+	assert.True(t, w.isDuplicate("a"))
+	assert.False(t, w.isDuplicate("b"))
+
+	//over MaxEntries, the oldest key is forgotten
+	assert.False(t, w.isDuplicate("c"))
+	assert.False(t, w.isDuplicate("a"))
+
+	//past the window everything is forgotten
+	now = now.Add(time.Minute * 2)
+	assert.False(t, w.isDuplicate("c"))
+}
+
+func TestDedupWindow_compacts(t *testing.T) {
+	config = createDefaultConfig()
+	config.Dedup.Window = 0
+	config.Dedup.MaxEntries = 100
+
+	w := newDedupWindow()
+	for i := 0; i < 10000; i++ {
+		assert.False(t, w.isDuplicate(fmt.Sprintf("key%d", i)))
+	}
+	assert.Len(t, w.entries(), 100)
+	assert.Len(t, w.keys, 100)
+	assert.True(t, len(w.order) <= 200, "expired entries are compacted away")
+	assert.Equal(t, "key9900", w.entries()[0].Key)
+	assert.True(t, w.isDuplicate("key9900"))
+	assert.False(t, w.isDuplicate("key9899"))
+}
+
+func TestDedupPersist(t *testing.T) {
+	folder, err := ioutil.TempDir("", "saucepan_dedup_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(folder)
+	persist := filepath.Join(folder, "dedup.json")
+
+	config = createDefaultConfig()
+	w := newDedupWindow()
+	assert.False(t, w.isDuplicate("a"))
+	assert.NoError(t, w.save(persist))
+
+	loaded := newDedupWindow()
+	assert.NoError(t, loaded.load(persist))
+	assert.True(t, loaded.isDuplicate("a"))
+	assert.False(t, loaded.isDuplicate("b"))
+}
+
+func TestDedup_failedRunForgotten(t *testing.T) {
+	config = createDefaultConfig()
+	config.CyberSaucier.Enabled = false
+	config.Dedup.Enabled = true
+	dedup = newDedupWindow()
+	defer func() { dedup = nil }()
+
+	//a run that failed forgets its records, so the retry isn't dropped as duplicates
+	run := newFileRun("/tmp/a_1.csv", config.defaultProfile(), nil, ',')
+	run.processRecord(1, []string{"1", "2"}, nil)
+	run.processRecord(2, []string{"1", "2"}, nil)
+	assert.Equal(t, 1, run.summary.Duplicates)
+	run.settleDedup(true)
+
+	retry := newFileRun("/tmp/a_1.csv", config.defaultProfile(), nil, ',')
+	retry.processRecord(1, []string{"1", "2"}, nil)
+	assert.Equal(t, 0, retry.summary.Duplicates)
+	retry.settleDedup(false)
+
+	//once sent, they are remembered
+	again := newFileRun("/tmp/b_1.csv", config.defaultProfile(), nil, ',')
+	again.processRecord(1, []string{"1", "2"}, nil)
+	assert.Equal(t, 1, again.summary.Duplicates)
+	again.settleDedup(true)
+	assert.Len(t, dedup.entries(), 1)
+}
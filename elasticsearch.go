@@ -2,24 +2,39 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	elastic "github.com/olivere/elastic"
 	log "github.com/sirupsen/logrus"
 )
 
+//queuedDoc - a document waiting for the next bulk insert, along with the start of the index it goes to
+//and the file it came from ("" for documents that don't belong to one, like summaries)
+type queuedDoc struct {
+	IndexStart string
+	File       string
+	Doc        map[string]interface{}
+}
+
 var (
-	queue []map[string]interface{}
+	queue     []queuedDoc
+	queueLock sync.Mutex
+	//esFailures - the first error for each file with documents that were not indexed, whichever file's send flushed them
+	esFailures = make(map[string]error)
 
 	esClient  *elastic.Client
 	esContext context.Context
 	lastFlush time.Time
+	esLimiter *rateLimiter
 )
 
 func initES() {
 	var err error
 	esContext = context.Background()
-	queue = make([]map[string]interface{}, 0)
+	queue = make([]queuedDoc, 0)
+	esLimiter = newRateLimiter(config.ElasticSearch.MaxRecordsPerSecond)
 	if config.ElasticSearch.UserName != "" {
 		if config.ElasticSearch.UseSimpleClient {
 			esClient, err = elastic.NewSimpleClient(elastic.SetURL(config.ElasticSearch.URL), elastic.SetBasicAuth(config.ElasticSearch.UserName, config.ElasticSearch.Password))
@@ -38,39 +53,111 @@ func initES() {
 	}
 }
 
-func flushQueue() {
-	index := config.ElasticSearch.IndexStart + time.Now().Format(config.ElasticSearch.DTMask)
+//flushQueue - sends everything queued to ElasticSearch, returning an error if any of it was not indexed
+func flushQueue() error {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+	return flushQueueLocked()
+}
+
+//flushFile - sends everything queued to ElasticSearch, returning an error if any of the file's documents
+//were not indexed, by this flush or an earlier one since the file started
+func flushFile(fullpath string) error {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+	flushQueueLocked()
+	return esFailures[fullpath]
+}
+
+//forgetESFailures - clears what was recorded for a file, when it starts (again) or is finished with
+func forgetESFailures(fullpath string) {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+	delete(esFailures, fullpath)
+}
+
+//failFile - records the first error for a file's documents, the lock must be held
+func failFile(fullpath string, err error) {
+	if fullpath == "" {
+		return
+	}
+	if _, ok := esFailures[fullpath]; !ok {
+		esFailures[fullpath] = err
+	}
+}
+
+func flushQueueLocked() error {
+	dt := time.Now().Format(config.ElasticSearch.DTMask)
 
 	if len(queue) > 0 {
 		req := esClient.Bulk()
-		for _, obj := range queue {
-			breq := elastic.NewBulkIndexRequest().Index(index).Type(config.ElasticSearch.Type).Doc(obj)
+		for _, item := range queue {
+			breq := elastic.NewBulkIndexRequest().Index(item.IndexStart + dt).Type(config.ElasticSearch.Type).Doc(item.Doc)
 			req.Add(breq)
 		}
 
 		resp, err := req.Do(esContext)
+		sent := queue
+		queue = make([]queuedDoc, 0)
 		if err != nil {
 			log.WithError(err).Warn("Unable to push data to ElasticSearch")
+			for _, item := range sent {
+				failFile(item.File, err)
+			}
+			return err
 		}
 
 		log.WithField("Result", resp).Debug("ElasticSearch Response")
-		queue = make([]map[string]interface{}, 0)
+		if resp.Errors {
+			failed := resp.Failed()
+			err = fmt.Errorf("%d of %d documents were not indexed", len(failed), len(resp.Items))
+			if len(failed) > 0 && failed[0].Error != nil {
+				err = fmt.Errorf("%s: %s", err, failed[0].Error.Reason)
+			}
+			log.WithError(err).Warn("Unable to push data to ElasticSearch")
+
+			//the items come back in the order they were sent
+			for i, item := range resp.Items {
+				for _, result := range item {
+					if i < len(sent) && !(result.Status >= 200 && result.Status <= 299) {
+						failFile(sent[i].File, err)
+					}
+				}
+			}
+			return err
+		}
 	}
+	return nil
 }
 
-func sendDataToES(object map[string]interface{}) error {
+//sendDataToES - queues the object (from fullpath, or "") for the index starting with indexStart, flushing the queue
+//when it is full; the error is from that flush, for a file only if any of its documents were not indexed
+func sendDataToES(fullpath string, indexStart string, object map[string]interface{}) error {
+	var err error
 	if config.ElasticSearch.Enabled {
-		queue = append(queue, object)
+		//blocks the file reader when over MaxRecordsPerSecond
+		esLimiter.wait()
+
+		queueLock.Lock()
+		queue = append(queue, queuedDoc{IndexStart: indexStart, File: fullpath, Doc: object})
 		lastOutputActionTime = time.Now()
 
+		flushed := false
 		if len(queue) >= config.ElasticSearch.QueueSize || int(time.Since(lastFlush).Seconds()) >= config.WaitInterval {
-			flushQueue()
+			err = flushQueueLocked()
+			if fullpath != "" {
+				err = esFailures[fullpath]
+			}
 			lastFlush = time.Now()
+			flushed = true
+		}
+		queueLock.Unlock()
 
+		if flushed {
 			log.WithField("Seconds", config.ElasticSearch.Sleep).Debug("Sleeping")
 			time.Sleep(time.Second * time.Duration(config.ElasticSearch.Sleep))
 		}
 
 	}
-	return nil
+	return err
 }
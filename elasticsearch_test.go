@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendDataToES_failuresPerFile(t *testing.T) {
+	down := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":true,"items":[` +
+			`{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"bad field"}}},` +
+			`{"index":{"status":201}}]}`))
+	}))
+	defer server.Close()
+
+	config = createDefaultConfig()
+	config.ElasticSearch.Enabled = true
+	config.ElasticSearch.URL = server.URL
+	config.ElasticSearch.QueueSize = 2
+	config.WaitInterval = 3600
+	initES()
+	lastFlush = time.Now()
+	defer forgetESFailures("a.csv")
+	defer forgetESFailures("b.csv")
+
+	//b's send flushes a's document too, only a's failed
+	assert.NoError(t, sendDataToES("a.csv", "test-", map[string]interface{}{"n": 1}))
+	assert.NoError(t, sendDataToES("b.csv", "test-", map[string]interface{}{"n": 2}))
+	assert.Error(t, flushFile("a.csv"))
+	assert.NoError(t, flushFile("b.csv"))
+
+	//a failure sticks to the file until it starts again
+	assert.Error(t, flushFile("a.csv"))
+	forgetESFailures("a.csv")
+	assert.NoError(t, flushFile("a.csv"))
+
+	//when the request fails every file in it fails
+	down = true
+	assert.NoError(t, sendDataToES("a.csv", "test-", map[string]interface{}{"n": 3}))
+	assert.Error(t, sendDataToES("b.csv", "test-", map[string]interface{}{"n": 4}))
+	assert.Error(t, flushFile("a.csv"))
+	assert.Error(t, flushFile("b.csv"))
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//A small, side-effect free expression language for record rules, e.g.
+//    Tag == "proxy" && status >= 400
+//    url =~ "(?i)\.exe$" || contains(lower(user_agent), "curl")
+//    dest_port in ["22", "3389"] && !exists(user)
+//Identifiers are record fields (use `backticks` for names with other characters), a missing field is null.
+//Comparisons are numeric when both sides are (finite) numbers, otherwise == and != compare strings
+//and < <= > >= are false.
+//When a field holds an array, a comparison is true if it is true for any element.
+
+type exprNode interface {
+	eval(obj map[string]interface{}) interface{}
+}
+
+type exprLiteral struct{ value interface{} }
+type exprField struct{ name string }
+type exprList struct{ items []exprNode }
+type exprNot struct{ operand exprNode }
+type exprLogical struct {
+	op          string
+	left, right exprNode
+}
+type exprCompare struct {
+	op          string
+	left, right exprNode
+}
+type exprCall struct {
+	name string
+	args []exprNode
+}
+
+type exprToken struct {
+	kind  string //"ident", "string", "number", "op", "eof"
+	value string
+	pos   int
+}
+
+var (
+	exprCache     = make(map[string]exprNode)
+	exprCacheLock sync.Mutex
+
+	exprFunctions = map[string]int{
+		"contains":   2,
+		"startsWith": 2,
+		"endsWith":   2,
+		"lower":      1,
+		"upper":      1,
+		"len":        1,
+		"exists":     1,
+	}
+)
+
+//getExpr - compiles (and caches) an expression
+func getExpr(src string) (exprNode, error) {
+	exprCacheLock.Lock()
+	defer exprCacheLock.Unlock()
+
+	if node, ok := exprCache[src]; ok {
+		return node, nil
+	}
+	node, err := compileExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	exprCache[src] = node
+	return node, nil
+}
+
+func tokenizeExpr(src string) ([]exprToken, error) {
+	tokens := make([]exprToken, 0)
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+					switch runes[j] {
+					case 'n':
+						sb.WriteRune('\n')
+					case 'r':
+						sb.WriteRune('\r')
+					case 't':
+						sb.WriteRune('\t')
+					case '"', '\'', '\\':
+						sb.WriteRune(runes[j])
+					default:
+						//keep unknown escapes as-is, so regular expressions read naturally
+						sb.WriteRune('\\')
+						sb.WriteRune(runes[j])
+					}
+					continue
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: "string", value: sb.String(), pos: i})
+			i = j + 1
+		case r == '`':
+			j := i + 1
+			for j < len(runes) && runes[j] != '`' {
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated field name at %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: "ident", value: string(runes[i+1 : j]), pos: i})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: "number", value: string(runes[i:j]), pos: i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", value: string(runes[i:j]), pos: i})
+			i = j
+		default:
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+			switch two {
+			case "==", "!=", "<=", ">=", "&&", "||", "=~", "!~":
+				tokens = append(tokens, exprToken{kind: "op", value: two, pos: i})
+				i += 2
+				continue
+			}
+			switch r {
+			case '<', '>', '!', '(', ')', '[', ']', ',':
+				tokens = append(tokens, exprToken{kind: "op", value: string(r), pos: i})
+				i++
+			default:
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+		}
+	}
+	return append(tokens, exprToken{kind: "eof", pos: len(runes)}), nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) isOp(values ...string) bool {
+	t := p.peek()
+	if t.kind != "op" && !(t.kind == "ident" && t.value == "in") {
+		return false
+	}
+	for _, v := range values {
+		if t.value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) expect(value string) error {
+	if t := p.next(); t.kind != "op" || t.value != value {
+		return fmt.Errorf("expected %q at %d", value, t.pos)
+	}
+	return nil
+}
+
+//compileExpr - parses an expression, regular expressions are checked at compile time
+func compileExpr(src string) (exprNode, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, fmt.Errorf("unexpected %q at %d", t.value, t.pos)
+	}
+	return node, nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprLogical{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprLogical{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.isOp("!") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNot{operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("==", "!=", "<", "<=", ">", ">=", "=~", "!~", "in") {
+		op := p.next().value
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "=~" || op == "!~" {
+			lit, ok := right.(*exprLiteral)
+			if !ok {
+				return nil, fmt.Errorf("%s needs a string pattern", op)
+			}
+			pattern, ok := lit.value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s needs a string pattern", op)
+			}
+			if _, err := getRegex(pattern); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := right.(*exprList); op == "in" && !ok {
+			return nil, fmt.Errorf("in needs a [list]")
+		}
+		return &exprCompare{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case "string":
+		return &exprLiteral{value: t.value}, nil
+	case "number":
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.value, t.pos)
+		}
+		return &exprLiteral{value: f}, nil
+	case "ident":
+		switch t.value {
+		case "true":
+			return &exprLiteral{value: true}, nil
+		case "false":
+			return &exprLiteral{value: false}, nil
+		case "null":
+			return &exprLiteral{value: nil}, nil
+		}
+		if argc, ok := exprFunctions[t.value]; ok && p.isOp("(") {
+			p.next()
+			args := make([]exprNode, 0)
+			for !p.isOp(")") {
+				if len(args) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				arg, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+			}
+			p.next()
+			if len(args) != argc {
+				return nil, fmt.Errorf("%s takes %d argument(s)", t.value, argc)
+			}
+			return &exprCall{name: t.value, args: args}, nil
+		}
+		return &exprField{name: t.value}, nil
+	case "op":
+		switch t.value {
+		case "(":
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		case "[":
+			items := make([]exprNode, 0)
+			for !p.isOp("]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			p.next()
+			return &exprList{items: items}, nil
+		}
+	}
+	if t.kind == "eof" {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.value, t.pos)
+}
+
+func (n *exprLiteral) eval(obj map[string]interface{}) interface{} {
+	return n.value
+}
+
+func (n *exprField) eval(obj map[string]interface{}) interface{} {
+	if v, ok := obj[n.name]; ok {
+		return v
+	}
+	//dotted names walk into nested objects (e.g. from SubParsers)
+	var current interface{} = obj
+	for _, part := range strings.Split(n.name, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
+
+func (n *exprList) eval(obj map[string]interface{}) interface{} {
+	ans := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		ans[i] = item.eval(obj)
+	}
+	return ans
+}
+
+func (n *exprNot) eval(obj map[string]interface{}) interface{} {
+	return !exprTruthy(n.operand.eval(obj))
+}
+
+func (n *exprLogical) eval(obj map[string]interface{}) interface{} {
+	left := exprTruthy(n.left.eval(obj))
+	if n.op == "&&" {
+		return left && exprTruthy(n.right.eval(obj))
+	}
+	return left || exprTruthy(n.right.eval(obj))
+}
+
+func (n *exprCompare) eval(obj map[string]interface{}) interface{} {
+	left := n.left.eval(obj)
+	right := n.right.eval(obj)
+
+	//negated operators are true only if no element matches
+	switch n.op {
+	case "!=":
+		return !exprAny(left, func(v interface{}) bool { return exprCompareValues("==", v, right) })
+	case "!~":
+		return !exprAny(left, func(v interface{}) bool { return exprCompareValues("=~", v, right) })
+	}
+	return exprAny(left, func(v interface{}) bool { return exprCompareValues(n.op, v, right) })
+}
+
+func (n *exprCall) eval(obj map[string]interface{}) interface{} {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.eval(obj)
+	}
+
+	switch n.name {
+	case "exists":
+		return args[0] != nil
+	case "len":
+		switch v := args[0].(type) {
+		case string:
+			return float64(len(v))
+		case []string:
+			return float64(len(v))
+		case []interface{}:
+			return float64(len(v))
+		case map[string]interface{}:
+			return float64(len(v))
+		}
+		return float64(0)
+	case "lower":
+		return exprMapStrings(args[0], strings.ToLower)
+	case "upper":
+		return exprMapStrings(args[0], strings.ToUpper)
+	}
+
+	needle := exprString(args[1])
+	return exprAny(args[0], func(v interface{}) bool {
+		s := exprString(v)
+		switch n.name {
+		case "contains":
+			return strings.Contains(s, needle)
+		case "startsWith":
+			return strings.HasPrefix(s, needle)
+		default:
+			return strings.HasSuffix(s, needle)
+		}
+	})
+}
+
+//exprAny - applies the test to a value, or to each element if it is an array
+func exprAny(value interface{}, test func(v interface{}) bool) bool {
+	switch v := value.(type) {
+	case []string:
+		for _, item := range v {
+			if test(item) {
+				return true
+			}
+		}
+		return false
+	case []interface{}:
+		for _, item := range v {
+			if test(item) {
+				return true
+			}
+		}
+		return false
+	}
+	return test(value)
+}
+
+func exprMapStrings(value interface{}, fn func(string) string) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []string:
+		ans := make([]string, len(v))
+		for i, item := range v {
+			ans[i] = fn(item)
+		}
+		return ans
+	case []interface{}:
+		ans := make([]string, len(v))
+		for i, item := range v {
+			ans[i] = fn(exprString(item))
+		}
+		return ans
+	}
+	return fn(exprString(value))
+}
+
+func exprString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+//exprNumber - the value as a number; NaN and infinities ("NaN", "inf" in a CSV) are not numbers here
+func exprNumber(value interface{}) (float64, bool) {
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		var err error
+		if f, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+func exprTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != "" && !strings.EqualFold(v, "false")
+	case []string:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	if f, ok := exprNumber(value); ok {
+		return f != 0
+	}
+	return true
+}
+
+func exprCompareValues(op string, left interface{}, right interface{}) bool {
+	switch op {
+	case "in":
+		for _, item := range right.([]interface{}) {
+			if exprCompareValues("==", left, item) {
+				return true
+			}
+		}
+		return false
+	case "=~":
+		if left == nil {
+			return false
+		}
+		re, err := getRegex(exprString(right))
+		return err == nil && re.MatchString(exprString(left))
+	}
+
+	if left == nil || right == nil {
+		if op == "==" {
+			return left == nil && right == nil
+		}
+		return false
+	}
+	if lb, ok := left.(bool); ok {
+		rb, ok := right.(bool)
+		return op == "==" && ok && lb == rb
+	}
+
+	var cmp int
+	lf, lok := exprNumber(left)
+	rf, rok := exprNumber(right)
+	if lok && rok {
+		switch {
+		case lf < rf:
+			cmp = -1
+		case lf > rf:
+			cmp = 1
+		}
+	} else if op == "==" {
+		return exprString(left) == exprString(right)
+	} else {
+		//only numbers have an order, "N/A" >= 400 or "50" > "400" would be nonsense
+		return false
+	}
+
+	switch op {
+	case "==":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
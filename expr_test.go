@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExprEval(t *testing.T) {
+	obj := map[string]interface{}{
+		"Tag":        "proxy",
+		"status":     "404",
+		"bytes":      int64(1200),
+		"url":        "http://example.com/setup.EXE",
+		"user_agent": "Mozilla/5.0",
+		"dest_port":  []string{"80", "3389"},
+		"blocked":    false,
+		"X-Client":   "10.0.0.1",
+		"url_parts":  map[string]interface{}{"host": "example.com"},
+		"enabled":    "False",
+		"version":    "N/A",
+		"ratio":      "NaN",
+		"limit":      "inf",
+	}
+
+	cases := map[string]bool{
+		`Tag == "proxy" && status >= 400`:              true,
+		`Tag == "proxy" && status >= 500`:              false,
+		`Tag != "proxy" || bytes > 1000`:               true,
+		`url =~ "(?i)\.exe$"`:                          true,
+		`url !~ "(?i)\.exe$"`:                          false,
+		`contains(lower(user_agent), "mozilla")`:       true,
+		`startsWith(url, "https")`:                     false,
+		`endsWith(url, ".EXE")`:                        true,
+		`dest_port == "3389"`:                          true,
+		`dest_port in ["22", "3389"]`:                  true,
+		`dest_port != "80"`:                            false,
+		`!exists(user) && exists(Tag)`:                 true,
+		`blocked == false`:                             true,
+		"`X-Client` == '10.0.0.1'":                     true,
+		`url_parts.host == "example.com"`:              true,
+		`missing == null`:                              true,
+		`len(dest_port) == 2`:                          true,
+		`(status == 404 || status == 403) && !blocked`: true,
+		`bytes < -1`:                                   false,
+		`status >= "N/A"`:                              false,
+		`Tag > "a"`:                                    false,
+		`Tag <= "proxy"`:                               false,
+		`enabled`:                                      false,
+		`!enabled`:                                     true,
+		`version > 400`:                                false,
+		`ratio < 1`:                                    false,
+		`ratio >= 1`:                                   false,
+		`limit > 1000`:                                 false,
+		`limit == "inf"`:                               true,
+	}
+
+	for src, expected := range cases {
+		node, err := compileExpr(src)
+		if assert.NoError(t, err, src) {
+			assert.Equal(t, expected, exprTruthy(node.eval(obj)), src)
+		}
+	}
+}
+
+func TestExprCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		`Tag ==`,
+		`(Tag == "a"`,
+		`Tag == "unterminated`,
+		`url =~ "(unclosed"`,
+		`url =~ other_field`,
+		`dest_port in "22"`,
+		`contains(url)`,
+		`Tag = "a"`,
+		`Tag == "a" extra`,
+	} {
+		_, err := compileExpr(src)
+		assert.Error(t, err, src)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package main
+
+import "syscall"
+
+//network filesystem magic numbers, from statfs(2)
+var networkFSTypes = map[uint32]string{
+	0x6969:     "nfs",
+	0x517B:     "smb",
+	0xFF534D42: "cifs",
+	0xFE534D42: "smb2",
+	0x65735546: "fuse",
+	0x564C:     "ncp",
+	0x73757245: "coda",
+	0x47504653: "gpfs",
+}
+
+//isNetworkFS - true if the folder is on a filesystem that fsnotify gets no (or only local) events from
+func isNetworkFS(fullpath string) (bool, string) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(fullpath, &stat); err != nil {
+		return false, ""
+	}
+	name, ok := networkFSTypes[uint32(stat.Type)]
+	return ok, name
+}
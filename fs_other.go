@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+//isNetworkFS - filesystem detection is only done on linux, elsewhere WatchMode "poll" must be set explicitly
+func isNetworkFS(fullpath string) (bool, string) {
+	return false, ""
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+	log "github.com/sirupsen/logrus"
+)
+
+type geoipConfig struct {
+	Enabled        bool     `json:"Enabled"`
+	CityDatabase   string   `json:"CityDatabase"`
+	ASNDatabase    string   `json:"ASNDatabase"`
+	Fields         []string `json:"Fields"`
+	IncludeHits    bool     `json:"IncludeHits"`
+	ReloadInterval int      `json:"ReloadInterval"`
+}
+
+type geoCityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type geoASNRecord struct {
+	Number uint   `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+//mmdbDatabase - a MaxMind database that is reopened when the file changes on disk
+type mmdbDatabase struct {
+	path   string
+	stamp  fileStamp
+	lock   sync.RWMutex
+	reader *maxminddb.Reader
+}
+
+var (
+	geoCityDB *mmdbDatabase
+	geoASNDB  *mmdbDatabase
+)
+
+func openMMDB(fullpath string) *mmdbDatabase {
+	if fullpath == "" {
+		return nil
+	}
+	db := &mmdbDatabase{path: fullpath}
+	if err := db.reload(); err != nil {
+		log.WithError(err).WithField("Database", fullpath).Warn("Unable to open GeoIP database")
+	}
+	return db
+}
+
+func (db *mmdbDatabase) reload() error {
+	reader, err := maxminddb.Open(db.path)
+	if err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	old := db.reader
+	db.reader = reader
+	db.stamp.update(db.path)
+	db.lock.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	log.WithField("Database", db.path).Info("Loaded GeoIP database")
+	return nil
+}
+
+func (db *mmdbDatabase) reloadIfChanged() {
+	changed, err := db.stamp.hasChanged(db.path)
+	if err != nil {
+		log.WithError(err).WithField("Database", db.path).Debug("Unable to check GeoIP database")
+		return
+	}
+	if changed {
+		if err := db.reload(); err != nil {
+			log.WithError(err).WithField("Database", db.path).Warn("Unable to reload GeoIP database")
+		}
+	}
+}
+
+func (db *mmdbDatabase) lookup(ip net.IP, result interface{}) bool {
+	if db == nil {
+		return false
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.reader == nil {
+		return false
+	}
+
+	_, ok, err := db.reader.LookupNetwork(ip, result)
+	if err != nil {
+		log.WithError(err).WithField("IP", ip).Debug("GeoIP lookup failed")
+		return false
+	}
+	return ok
+}
+
+func initGeoIP() {
+	if !config.GeoIP.Enabled {
+		return
+	}
+	geoCityDB = openMMDB(config.GeoIP.CityDatabase)
+	geoASNDB = openMMDB(config.GeoIP.ASNDatabase)
+
+	startReloader(config.GeoIP.ReloadInterval, func() {
+		for _, db := range []*mmdbDatabase{geoCityDB, geoASNDB} {
+			if db != nil {
+				db.reloadIfChanged()
+			}
+		}
+	})
+}
+
+//fieldStrings - returns the string value(s) of a record field
+func fieldStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		ans := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				ans = append(ans, s)
+			}
+		}
+		return ans
+	}
+	return nil
+}
+
+//ipField - an IP address and the record field it came from
+type ipField struct {
+	Field string
+	IP    net.IP
+}
+
+//collectIPs - gathers the unique IPs in the given fields (and optionally the hits) of a record
+func collectIPs(obj map[string]interface{}, fields []string, includeHits bool) []ipField {
+	seen := make(map[string]bool)
+	ans := make([]ipField, 0)
+	add := func(field string, value string) {
+		ip := net.ParseIP(value)
+		if ip == nil || seen[field+"|"+ip.String()] {
+			return
+		}
+		seen[field+"|"+ip.String()] = true
+		ans = append(ans, ipField{Field: field, IP: ip})
+	}
+
+	for _, field := range fields {
+		for _, value := range fieldStrings(obj[field]) {
+			add(field, value)
+		}
+	}
+	if includeHits {
+		for _, value := range fieldStrings(obj["Hits"]) {
+			add("Hits", value)
+		}
+	}
+	return ans
+}
+
+//enrichGeoIP - adds "geo" (country and lat/lon location) and "asn" arrays, an entry for each IP in a record
+func enrichGeoIP(obj map[string]interface{}) {
+	if !config.GeoIP.Enabled {
+		return
+	}
+
+	geos := make([]map[string]interface{}, 0)
+	asns := make([]map[string]interface{}, 0)
+	for _, item := range collectIPs(obj, config.GeoIP.Fields, config.GeoIP.IncludeHits) {
+		var city geoCityRecord
+		if geoCityDB.lookup(item.IP, &city) {
+			geo := map[string]interface{}{
+				"field":   item.Field,
+				"ip":      item.IP.String(),
+				"country": city.Country.ISOCode,
+			}
+			//Country databases (and some city records) have no coordinates, 0,0 would be a real place
+			if city.Location.Latitude != nil && city.Location.Longitude != nil {
+				geo["location"] = map[string]float64{"lat": *city.Location.Latitude, "lon": *city.Location.Longitude}
+			}
+			if name, ok := city.Country.Names["en"]; ok {
+				geo["country_name"] = name
+			}
+			if name, ok := city.City.Names["en"]; ok {
+				geo["city"] = name
+			}
+			geos = append(geos, geo)
+		}
+
+		var asn geoASNRecord
+		if geoASNDB.lookup(item.IP, &asn) {
+			asns = append(asns, map[string]interface{}{
+				"field":  item.Field,
+				"ip":     item.IP.String(),
+				"number": asn.Number,
+				"org":    asn.Org,
+			})
+		}
+	}
+
+	if len(geos) > 0 {
+		obj["geo"] = geos
+	}
+	if len(asns) > 0 {
+		obj["asn"] = asns
+	}
+}
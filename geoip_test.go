@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectIPs(t *testing.T) {
+	obj := map[string]interface{}{
+		"src_ip":  "10.0.0.1",
+		"dest_ip": []string{"8.8.8.8", "not an ip", "8.8.8.8"},
+		"Hits":    []string{"evil.example.com", "1.2.3.4"},
+	}
+
+	ips := collectIPs(obj, []string{"src_ip", "dest_ip", "missing"}, true)
+	assert.Equal(t, []ipField{
+		{Field: "src_ip", IP: net.ParseIP("10.0.0.1")},
+		{Field: "dest_ip", IP: net.ParseIP("8.8.8.8")},
+		{Field: "Hits", IP: net.ParseIP("1.2.3.4")},
+	}, ips)
+
+	assert.Len(t, collectIPs(obj, []string{"src_ip"}, false), 1)
+}
+
+func TestEnrichGeoIP_noDatabase(t *testing.T) {
+	config = createDefaultConfig()
+	config.GeoIP.Enabled = true
+	geoCityDB = openMMDB("")
+	geoASNDB = openMMDB("/does/not/exist.mmdb")
+
+	obj := map[string]interface{}{"src_ip": "8.8.8.8"}
+	enrichGeoIP(obj)
+	assert.NotContains(t, obj, "geo")
+	assert.NotContains(t, obj, "asn")
+}
+
+//the databases in testdata have 8.8.8.0/24 (city, coordinates and ASN) and 1.1.1.0/24 (country only)
+func TestEnrichGeoIP(t *testing.T) {
+	config = createDefaultConfig()
+	config.GeoIP.Enabled = true
+	geoCityDB = openMMDB(filepath.Join("testdata", "GeoIP2-City-Test.mmdb"))
+	geoASNDB = openMMDB(filepath.Join("testdata", "GeoLite2-ASN-Test.mmdb"))
+	defer func() {
+		geoCityDB = nil
+		geoASNDB = nil
+	}()
+
+	obj := map[string]interface{}{"src_ip": "8.8.8.8", "dest_ip": "1.1.1.1", "Hits": []string{"10.0.0.1"}}
+	enrichGeoIP(obj)
+
+	geos, ok := obj["geo"].([]map[string]interface{})
+	if assert.True(t, ok) && assert.Len(t, geos, 2) {
+		assert.Equal(t, "src_ip", geos[0]["field"])
+		assert.Equal(t, "US", geos[0]["country"])
+		assert.Equal(t, "United States", geos[0]["country_name"])
+		assert.Equal(t, "Mountain View", geos[0]["city"])
+		assert.Equal(t, map[string]float64{"lat": 37.386, "lon": -122.0838}, geos[0]["location"])
+
+		assert.Equal(t, "dest_ip", geos[1]["field"])
+		assert.Equal(t, "AU", geos[1]["country"])
+		assert.NotContains(t, geos[1], "location")
+		assert.NotContains(t, geos[1], "city")
+	}
+
+	asns, ok := obj["asn"].([]map[string]interface{})
+	if assert.True(t, ok) && assert.Len(t, asns, 1) {
+		assert.Equal(t, "8.8.8.8", asns[0]["ip"])
+		assert.EqualValues(t, 15169, asns[0]["number"])
+		assert.Equal(t, "GOOGLE", asns[0]["org"])
+	}
+}
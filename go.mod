@@ -6,9 +6,10 @@ require (
 	github.com/fsnotify/fsnotify v1.4.7
 	github.com/mailru/easyjson v0.0.0-20190403194419-1ea4449da983 // indirect
 	github.com/olivere/elastic v6.2.17+incompatible
+	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/otium/queue v0.0.0-20130722223348-9aab6b722ecd
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/sirupsen/logrus v1.4.2
-	github.com/stretchr/testify v1.2.2
+	github.com/stretchr/testify v1.6.1
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
 )
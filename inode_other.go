@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "os"
+
+//fileInode - there are no inode numbers here, so rotation is only noticed by the file shrinking
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
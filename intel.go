@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type intelListConfig struct {
+	Name       string `json:"Name"`
+	File       string `json:"File"`
+	Format     string `json:"Format"`
+	Confidence int    `json:"Confidence"`
+}
+
+type threatIntelConfig struct {
+	Enabled        bool              `json:"Enabled"`
+	Lists          []intelListConfig `json:"Lists"`
+	ReloadInterval int               `json:"ReloadInterval"`
+}
+
+//intelEntry - a single indicator from a threat intel list
+type intelEntry struct {
+	List       string
+	Indicator  string
+	Type       string
+	Confidence int
+}
+
+//ipTrieNode - a node in a binary radix tree of CIDRs, entries are the indicators whose prefix ends here
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	entries  []intelEntry
+}
+
+//intelIndex - all of the loaded indicators, ready for matching
+type intelIndex struct {
+	v4      *ipTrieNode
+	v6      *ipTrieNode
+	domains map[string][]intelEntry
+	hashes  map[string][]intelEntry
+	values  map[string][]intelEntry
+	count   int
+}
+
+var (
+	stixValueRegex  = regexp.MustCompile(`=\s*'((?:[^'\\]|\\.)*)'`)
+	fullDomainRegex = regexp.MustCompile(`^(?:[a-z0-9_](?:[a-z0-9_\-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+	fullHashRegex   = regexp.MustCompile(`^[a-f0-9]{32}$|^[a-f0-9]{40}$|^[a-f0-9]{64}$|^[a-f0-9]{128}$`)
+
+	//bookkeeping and enrichment fields that are never checked against the lists
+	intelSkipFields = map[string]bool{
+		"FileName": true, "Line": true, "Tag": true, "DateTime": true, "Profile": true,
+		"CyberSaucier": true, "Intel": true, "IntelLists": true, "geo": true, "asn": true,
+	}
+
+	currentIntel     *intelIndex
+	currentIntelLock sync.RWMutex
+	intelStamps      []fileStamp
+)
+
+func newIntelIndex() *intelIndex {
+	return &intelIndex{
+		v4:      &ipTrieNode{},
+		v6:      &ipTrieNode{},
+		domains: make(map[string][]intelEntry),
+		hashes:  make(map[string][]intelEntry),
+		values:  make(map[string][]intelEntry),
+	}
+}
+
+//insert - adds the entry at the prefix, false (and nothing added) if the prefix is longer than the address
+func (n *ipTrieNode) insert(ip net.IP, prefixLen int, entry intelEntry) bool {
+	if prefixLen < 0 || prefixLen > len(ip)*8 {
+		return false
+	}
+	node := n
+	for i := 0; i < prefixLen; i++ {
+		bit := (ip[i/8] >> uint(7-i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &ipTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.entries = append(node.entries, entry)
+	return true
+}
+
+//lookup - returns the entries of every prefix that contains ip
+func (n *ipTrieNode) lookup(ip net.IP) []intelEntry {
+	ans := make([]intelEntry, 0)
+	node := n
+	for i := 0; node != nil; i++ {
+		ans = append(ans, node.entries...)
+		if i >= len(ip)*8 {
+			break
+		}
+		bit := (ip[i/8] >> uint(7-i%8)) & 1
+		node = node.children[bit]
+	}
+	return ans
+}
+
+//add - classifies the indicator as a CIDR/IP, hash, domain or plain value and indexes it
+func (idx *intelIndex) add(indicator string, list string, confidence int) {
+	indicator = strings.TrimSpace(indicator)
+	if indicator == "" {
+		return
+	}
+	idx.count++
+
+	if strings.Contains(indicator, "/") {
+		if _, cidr, err := net.ParseCIDR(indicator); err == nil {
+			ones, bits := cidr.Mask.Size()
+			entry := intelEntry{List: list, Indicator: indicator, Type: "cidr", Confidence: confidence}
+			inserted := false
+			if ip4 := cidr.IP.To4(); ip4 != nil {
+				//an IPv4-mapped IPv6 CIDR ("::ffff:10.0.0.0/104") has a 128 bit mask over the 32 bit address
+				if bits == 128 {
+					ones -= 96
+				}
+				inserted = idx.v4.insert(ip4, ones, entry)
+			} else {
+				inserted = idx.v6.insert(cidr.IP.To16(), ones, entry)
+			}
+			if !inserted {
+				idx.count--
+				log.WithFields(log.Fields{"List": list, "Indicator": indicator}).Warn("Invalid threat intel CIDR, skipped")
+			}
+			return
+		}
+	}
+
+	if ip := net.ParseIP(indicator); ip != nil {
+		entry := intelEntry{List: list, Indicator: indicator, Type: "ip", Confidence: confidence}
+		if ip4 := ip.To4(); ip4 != nil {
+			idx.v4.insert(ip4, 32, entry)
+		} else {
+			idx.v6.insert(ip.To16(), 128, entry)
+		}
+		return
+	}
+
+	lower := strings.ToLower(indicator)
+	if fullHashRegex.MatchString(lower) {
+		idx.hashes[lower] = append(idx.hashes[lower], intelEntry{List: list, Indicator: indicator, Type: "hash", Confidence: confidence})
+		return
+	}
+
+	domain := strings.TrimSuffix(strings.TrimPrefix(lower, "*."), ".")
+	if fullDomainRegex.MatchString(domain) {
+		idx.domains[domain] = append(idx.domains[domain], intelEntry{List: list, Indicator: indicator, Type: "domain", Confidence: confidence})
+		return
+	}
+
+	idx.values[lower] = append(idx.values[lower], intelEntry{List: list, Indicator: indicator, Type: "value", Confidence: confidence})
+}
+
+//match - finds every indicator that matches the value, domains match on any parent domain (suffix)
+func (idx *intelIndex) match(value string) []intelEntry {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+
+	if ip := net.ParseIP(value); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return idx.v4.lookup(ip4)
+		}
+		return idx.v6.lookup(ip.To16())
+	}
+
+	lower := strings.ToLower(value)
+	ans := make([]intelEntry, 0)
+	ans = append(ans, idx.values[lower]...)
+	if entries, ok := idx.hashes[lower]; ok {
+		return append(ans, entries...)
+	}
+
+	domain := strings.TrimSuffix(lower, ".")
+	if strings.Contains(domain, "://") {
+		if u, err := url.Parse(value); err == nil && u.Hostname() != "" {
+			domain = strings.ToLower(u.Hostname())
+			if ip := net.ParseIP(domain); ip != nil {
+				return append(ans, idx.match(domain)...)
+			}
+		}
+	}
+	if fullDomainRegex.MatchString(domain) {
+		for {
+			ans = append(ans, idx.domains[domain]...)
+			dot := strings.Index(domain, ".")
+			if dot < 0 {
+				break
+			}
+			domain = domain[dot+1:]
+		}
+	}
+	return ans
+}
+
+//loadIntelCSV - one indicator per row, with an optional confidence in the second column; rows starting with "#" are skipped
+func loadIntelCSV(idx *intelIndex, list intelListConfig) error {
+	f, err := os.Open(list.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(row) == 0 || strings.EqualFold(row[0], "indicator") {
+			continue
+		}
+
+		confidence := list.Confidence
+		if len(row) > 1 {
+			if c, err := strconv.Atoi(strings.TrimSpace(row[1])); err == nil {
+				confidence = c
+			}
+		}
+		idx.add(row[0], list.Name, confidence)
+	}
+	return nil
+}
+
+//loadIntelSTIX - reads the indicator patterns out of a STIX 2 bundle
+func loadIntelSTIX(idx *intelIndex, list intelListConfig) error {
+	data, err := ioutil.ReadFile(list.File)
+	if err != nil {
+		return err
+	}
+
+	var bundle struct {
+		Objects []struct {
+			Type       string `json:"type"`
+			Pattern    string `json:"pattern"`
+			Confidence *int   `json:"confidence"`
+		} `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return err
+	}
+
+	for _, obj := range bundle.Objects {
+		if obj.Type != "indicator" {
+			continue
+		}
+		confidence := list.Confidence
+		if obj.Confidence != nil {
+			confidence = *obj.Confidence
+		}
+		for _, m := range stixValueRegex.FindAllStringSubmatch(obj.Pattern, -1) {
+			idx.add(strings.Replace(m[1], `\'`, `'`, -1), list.Name, confidence)
+		}
+	}
+	return nil
+}
+
+func loadThreatIntel() {
+	idx := newIntelIndex()
+	stamps := make([]fileStamp, len(config.ThreatIntel.Lists))
+
+	for i, list := range config.ThreatIntel.Lists {
+		var err error
+		if list.Format == "stix" {
+			err = loadIntelSTIX(idx, list)
+		} else {
+			err = loadIntelCSV(idx, list)
+		}
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"List": list.Name, "File": list.File}).Warn("Unable to load threat intel list")
+		}
+		stamps[i].update(list.File)
+	}
+
+	currentIntelLock.Lock()
+	currentIntel = idx
+	intelStamps = stamps
+	currentIntelLock.Unlock()
+
+	log.WithField("Indicators", idx.count).Info("Loaded threat intel")
+}
+
+func reloadThreatIntelIfChanged() {
+	currentIntelLock.RLock()
+	changed := false
+	for i, list := range config.ThreatIntel.Lists {
+		if c, err := intelStamps[i].hasChanged(list.File); err == nil && c {
+			changed = true
+			break
+		}
+	}
+	currentIntelLock.RUnlock()
+
+	if changed {
+		loadThreatIntel()
+	}
+}
+
+func initThreatIntel() {
+	if !config.ThreatIntel.Enabled {
+		return
+	}
+	loadThreatIntel()
+	startReloader(config.ThreatIntel.ReloadInterval, reloadThreatIntelIfChanged)
+}
+
+//matchThreatIntel - checks every field and hit of the record against the lists, returns true if anything matched
+func matchThreatIntel(obj map[string]interface{}) bool {
+	if !config.ThreatIntel.Enabled {
+		return false
+	}
+	currentIntelLock.RLock()
+	idx := currentIntel
+	currentIntelLock.RUnlock()
+	if idx == nil {
+		return false
+	}
+
+	matches := make([]map[string]interface{}, 0)
+	lists := make([]string, 0)
+	seen := make(map[string]bool)
+	var check func(field string, value interface{})
+	check = func(field string, value interface{}) {
+		switch v := value.(type) {
+		case string:
+			for _, entry := range idx.match(v) {
+				key := field + "|" + v + "|" + entry.List + "|" + entry.Indicator
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				matches = append(matches, map[string]interface{}{
+					"list":       entry.List,
+					"indicator":  entry.Indicator,
+					"type":       entry.Type,
+					"confidence": entry.Confidence,
+					"field":      field,
+					"value":      v,
+				})
+				lists = append(lists, entry.List)
+			}
+		case []string:
+			for _, item := range v {
+				check(field, item)
+			}
+		case []interface{}:
+			for _, item := range v {
+				check(field, item)
+			}
+		case map[string]interface{}:
+			for k, item := range v {
+				check(field+"."+k, item)
+			}
+		}
+	}
+
+	fields := make([]string, 0, len(obj))
+	for field := range obj {
+		if !intelSkipFields[field] {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		check(field, obj[field])
+	}
+
+	if len(matches) == 0 {
+		return false
+	}
+	obj["Intel"] = matches
+	obj["IntelLists"] = uniqueStrings(lists)
+	return true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntelIndexMatch(t *testing.T) {
+	idx := newIntelIndex()
+	idx.add("10.0.0.0/8", "internal", 10)
+	idx.add("10.1.0.0/16", "lab", 20)
+	idx.add("8.8.8.8", "resolvers", 30)
+	idx.add("2001:db8::/32", "v6", 40)
+	idx.add("evil.com", "domains", 50)
+	idx.add("D41D8CD98F00B204E9800998ECF8427E", "hashes", 60)
+	idx.add("bad value", "values", 70)
+
+	assert.Len(t, idx.match("10.1.2.3"), 2)
+	assert.Len(t, idx.match("10.2.2.3"), 1)
+	assert.Empty(t, idx.match("11.1.2.3"))
+	assert.Len(t, idx.match("8.8.8.8"), 1)
+	assert.Empty(t, idx.match("8.8.8.9"))
+	assert.Len(t, idx.match("2001:db8::1"), 1)
+	assert.Len(t, idx.match("www.Evil.com"), 1)
+	assert.Len(t, idx.match("http://a.evil.com/path"), 1)
+	assert.Empty(t, idx.match("notevil.com"))
+	assert.Len(t, idx.match("d41d8cd98f00b204e9800998ecf8427e"), 1)
+	assert.Len(t, idx.match("Bad Value"), 1)
+}
+
+func TestIntelIndexMatch_mappedCIDR(t *testing.T) {
+	idx := newIntelIndex()
+	idx.add("::ffff:10.0.0.0/104", "mapped", 10)
+	idx.add("::ffff:192.168.1.1/128", "mapped", 20)
+
+	assert.Equal(t, 2, idx.count)
+	assert.Len(t, idx.match("10.9.9.9"), 1)
+	assert.Empty(t, idx.match("11.0.0.1"))
+	assert.Len(t, idx.match("192.168.1.1"), 1)
+	assert.Empty(t, idx.match("192.168.1.2"))
+
+	//a prefix longer than the address is skipped, not a panic
+	assert.False(t, idx.v4.insert(net.ParseIP("10.0.0.1").To4(), 33, intelEntry{}))
+}
+
+func TestLoadAndMatchThreatIntel(t *testing.T) {
+	folder, err := ioutil.TempDir("", "saucepan_intel_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	csvFile := filepath.Join(folder, "ips.csv")
+	stixFile := filepath.Join(folder, "bundle.json")
+	assert.NoError(t, ioutil.WriteFile(csvFile, []byte("indicator,confidence\n# comment\n192.0.2.0/24,90\n198.51.100.7\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(stixFile, []byte(`{"type": "bundle", "objects": [
+		{"type": "indicator", "pattern": "[domain-name:value = 'evil.example']", "confidence": 75},
+		{"type": "malware", "name": "ignored"}
+	]}`), 0644))
+
+	config = createDefaultConfig()
+	config.ThreatIntel.Enabled = true
+	config.ThreatIntel.Lists = []intelListConfig{
+		{Name: "ips", File: csvFile, Confidence: 50},
+		{Name: "stix", File: stixFile, Format: "stix"},
+	}
+	loadThreatIntel()
+
+	obj := map[string]interface{}{
+		"FileName": "192.0.2.1",
+		"src_ip":   []string{"192.0.2.10", "203.0.113.1"},
+		"dest_ip":  "198.51.100.7",
+		"Hits":     []string{"cdn.evil.example"},
+	}
+	assert.True(t, matchThreatIntel(obj))
+	assert.Equal(t, []string{"stix", "ips"}, obj["IntelLists"])
+	assert.Equal(t, []map[string]interface{}{
+		{"list": "stix", "indicator": "evil.example", "type": "domain", "confidence": 75, "field": "Hits", "value": "cdn.evil.example"},
+		{"list": "ips", "indicator": "198.51.100.7", "type": "ip", "confidence": 50, "field": "dest_ip", "value": "198.51.100.7"},
+		{"list": "ips", "indicator": "192.0.2.0/24", "type": "cidr", "confidence": 90, "field": "src_ip", "value": "192.0.2.10"},
+	}, obj["Intel"])
+
+	assert.False(t, matchThreatIntel(map[string]interface{}{"src_ip": "203.0.113.1"}))
+}
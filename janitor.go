@@ -0,0 +1,387 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type retentionConfig struct {
+	Enabled           bool `json:"Enabled"`
+	Interval          int  `json:"Interval"`
+	DryRun            bool `json:"DryRun"`
+	CompressAfterDays int  `json:"CompressAfterDays"`
+	DeleteAfterDays   int  `json:"DeleteAfterDays"`
+	MaxSizeMB         int  `json:"MaxSizeMB"`
+	Bundle            bool `json:"Bundle"`
+}
+
+//janitorFile - a file in the DoneFolder the janitor may act on
+type janitorFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+//bundleManifest - written into every bundle, listing what went into it
+type bundleManifest struct {
+	Day     string        `json:"Day"`
+	Created time.Time     `json:"Created"`
+	Files   []bundleEntry `json:"Files"`
+}
+
+type bundleEntry struct {
+	Path    string    `json:"Path"`
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+	SHA256  string    `json:"SHA256"`
+}
+
+const (
+	bundlePrefix = "saucepan_"
+	bundleSuffix = ".tar.gz"
+)
+
+var (
+	janitorLock sync.Mutex
+	janitorNow  = time.Now
+)
+
+func initJanitor() {
+	if !config.Retention.Enabled || config.DoneFolder == "" {
+		return
+	}
+	go runJanitor()
+	startReloader(config.Retention.Interval, runJanitor)
+}
+
+//runJanitor - applies the Retention policies to the DoneFolder: bundle finished days, compress, delete old files, then cap the size
+func runJanitor() {
+	janitorLock.Lock()
+	defer janitorLock.Unlock()
+
+	root := config.DoneFolder
+	now := janitorNow()
+	files := listJanitorFiles(root)
+	log.WithFields(log.Fields{"Folder": root, "Files": len(files), "DryRun": config.Retention.DryRun}).Debug("Janitor running")
+
+	if config.Retention.Bundle {
+		files = bundleDays(root, files, now)
+	}
+	if config.Retention.CompressAfterDays > 0 {
+		files = compressOld(files, now.AddDate(0, 0, -config.Retention.CompressAfterDays))
+	}
+	if config.Retention.DeleteAfterDays > 0 {
+		files = deleteOld(files, now.AddDate(0, 0, -config.Retention.DeleteAfterDays))
+	}
+	if config.Retention.MaxSizeMB > 0 {
+		capSize(files, int64(config.Retention.MaxSizeMB)*1024*1024)
+	}
+	if !config.Retention.DryRun {
+		removeEmptyFolders(root)
+	}
+}
+
+func janitorLog(action string, fullpath string) *log.Entry {
+	return log.WithFields(log.Fields{"Action": action, "File": fullpath, "DryRun": config.Retention.DryRun})
+}
+
+//listJanitorFiles - every file below root, oldest first, leaving out moves still in progress
+func listJanitorFiles(root string) []janitorFile {
+	files := make([]janitorFile, 0)
+	err := filepath.Walk(root, func(fullpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.WithError(err).WithField("Path", fullpath).Debug("Error walking DoneFolder")
+			return nil
+		}
+		if info.Mode().IsRegular() && !strings.HasSuffix(fullpath, ".saucepan-tmp") {
+			files = append(files, janitorFile{path: fullpath, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).WithField("Folder", root).Warn("Error walking DoneFolder")
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	return files
+}
+
+//bundleRegex - "saucepan_{date}.tar.gz", or "saucepan_{date}_N.tar.gz" when a day is bundled again;
+//"saucepan_{date}.tar_N.gz" is how earlier versions named those
+var bundleRegex = regexp.MustCompile(`^saucepan_\d{4}-\d{2}-\d{2}(?:_\d+\.tar\.gz|\.tar\.gz|\.tar_\d+\.gz)$`)
+
+func isBundle(root string, fullpath string) bool {
+	return filepath.Dir(fullpath) == filepath.Clean(root) && bundleRegex.MatchString(filepath.Base(fullpath))
+}
+
+//bundleName - the first free name for a day's bundle, files that turn up for a day already bundled get "_1", "_2", ...
+func bundleName(root string, day string) string {
+	bundle := filepath.Join(root, bundlePrefix+day+bundleSuffix)
+	for i := 1; pathExists(bundle); i++ {
+		bundle = filepath.Join(root, fmt.Sprintf("%s%s_%d%s", bundlePrefix, day, i, bundleSuffix))
+	}
+	return bundle
+}
+
+//bundleDays - puts each finished day's files into one "saucepan_{date}.tar.gz" with a manifest, removing the files
+func bundleDays(root string, files []janitorFile, now time.Time) []janitorFile {
+	today := now.Format("2006-01-02")
+	days := make(map[string][]janitorFile)
+	order := make([]string, 0)
+	kept := make([]janitorFile, 0, len(files))
+	for _, f := range files {
+		day := f.modTime.Format("2006-01-02")
+		if day >= today || isBundle(root, f.path) {
+			kept = append(kept, f)
+			continue
+		}
+		if _, ok := days[day]; !ok {
+			order = append(order, day)
+		}
+		days[day] = append(days[day], f)
+	}
+
+	for _, day := range order {
+		bundle := bundleName(root, day)
+		janitorLog("bundle", bundle).WithField("Files", len(days[day])).Info("Janitor")
+		if config.Retention.DryRun {
+			//nothing was bundled, so the later passes still see (and report) the files themselves
+			kept = append(kept, days[day]...)
+			continue
+		}
+		f, err := writeBundle(root, bundle, day, days[day], now)
+		if err != nil {
+			janitorLog("bundle", bundle).WithError(err).Warn("Janitor could not bundle files")
+			kept = append(kept, days[day]...)
+			continue
+		}
+		for _, bundled := range days[day] {
+			if err := os.Remove(bundled.path); err != nil {
+				janitorLog("bundle", bundled.path).WithError(err).Warn("Janitor could not remove bundled file")
+			}
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+//writeBundle - writes the files, then manifest.json, to a tar.gz dated with the newest file
+func writeBundle(root string, bundle string, day string, files []janitorFile, now time.Time) (janitorFile, error) {
+	tmp := bundle + ".saucepan-tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return janitorFile{}, err
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	manifest := bundleManifest{Day: day, Created: now, Files: make([]bundleEntry, 0, len(files))}
+	newest := time.Time{}
+	for _, f := range files {
+		rel, err := filepath.Rel(root, f.path)
+		if err != nil {
+			rel = filepath.Base(f.path)
+		}
+		rel = filepath.ToSlash(rel)
+		hash, err := addToTar(tw, f, rel)
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return janitorFile{}, err
+		}
+		manifest.Files = append(manifest.Files, bundleEntry{Path: rel, Size: f.size, ModTime: f.modTime, SHA256: hash})
+		if f.modTime.After(newest) {
+			newest = f.modTime
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		err = tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(data)), ModTime: now})
+	}
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, bundle)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return janitorFile{}, err
+	}
+
+	//the bundle ages like the files in it
+	os.Chtimes(bundle, newest, newest)
+	info, err := os.Stat(bundle)
+	if err != nil {
+		return janitorFile{}, err
+	}
+	return janitorFile{path: bundle, size: info.Size(), modTime: newest}, nil
+}
+
+//addToTar - adds one file to the bundle, returning its SHA256
+func addToTar(tw *tar.Writer, f janitorFile, name string) (string, error) {
+	in, err := os.Open(f.path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: f.size, ModTime: f.modTime}); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(tw, h), in, f.size); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//compressOld - gzips files last modified before cutoff, keeping their modified time
+func compressOld(files []janitorFile, cutoff time.Time) []janitorFile {
+	for i, f := range files {
+		if !f.modTime.Before(cutoff) || strings.HasSuffix(f.path, ".gz") {
+			continue
+		}
+		janitorLog("compress", f.path).Info("Janitor")
+		if config.Retention.DryRun {
+			continue
+		}
+		compressed, err := gzipFile(f)
+		if err != nil {
+			janitorLog("compress", f.path).WithError(err).Warn("Janitor could not compress file")
+			continue
+		}
+		files[i] = compressed
+	}
+	return files
+}
+
+func gzipFile(f janitorFile) (janitorFile, error) {
+	in, err := os.Open(f.path)
+	if err != nil {
+		return f, err
+	}
+	defer in.Close()
+
+	tmp := f.path + ".gz.saucepan-tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return f, err
+	}
+	gz := gzip.NewWriter(out)
+	gz.Name = filepath.Base(f.path)
+	gz.ModTime = f.modTime
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		os.Chtimes(tmp, f.modTime, f.modTime)
+	}
+	var dst string
+	if err == nil {
+		dst, err = moveFile(tmp, f.path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return f, err
+	}
+	in.Close()
+	if err := os.Remove(f.path); err != nil {
+		return f, err
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		return f, err
+	}
+	return janitorFile{path: dst, size: info.Size(), modTime: f.modTime}, nil
+}
+
+//deleteOld - removes files last modified before cutoff
+func deleteOld(files []janitorFile, cutoff time.Time) []janitorFile {
+	kept := make([]janitorFile, 0, len(files))
+	for _, f := range files {
+		if f.modTime.Before(cutoff) && removeJanitorFile("delete", f) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+//capSize - removes the oldest files until the rest fit in maxBytes
+func capSize(files []janitorFile, maxBytes int64) []janitorFile {
+	total := int64(0)
+	for _, f := range files {
+		total += f.size
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	kept := make([]janitorFile, 0, len(files))
+	for _, f := range files {
+		if total > maxBytes && removeJanitorFile("cap", f) {
+			total -= f.size
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+func removeJanitorFile(action string, f janitorFile) bool {
+	janitorLog(action, f.path).WithField("Size", f.size).Info("Janitor")
+	if config.Retention.DryRun {
+		return true
+	}
+	if err := os.Remove(f.path); err != nil {
+		janitorLog(action, f.path).WithError(err).Warn("Janitor could not remove file")
+		return false
+	}
+	return true
+}
+
+//removeEmptyFolders - removes the subfolders left empty, deepest first (never root itself)
+func removeEmptyFolders(root string) {
+	dirs := make([]string, 0)
+	filepath.Walk(root, func(fullpath string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && fullpath != root {
+			dirs = append(dirs, fullpath)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if entries, err := ioutil.ReadDir(dirs[i]); err == nil && len(entries) == 0 {
+			if os.Remove(dirs[i]) == nil {
+				janitorLog("rmdir", dirs[i]).Debug("Janitor")
+			}
+		}
+	}
+}
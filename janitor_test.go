@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//janitorFolder - a DoneFolder with a file written the given number of days before now
+func janitorFolder(t *testing.T, now time.Time, ages map[string]int) string {
+	dir, err := ioutil.TempDir("", "saucepan_done_")
+	assert.NoError(t, err)
+	for name, days := range ages {
+		fullpath := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(fullpath), 0755))
+		assert.NoError(t, ioutil.WriteFile(fullpath, []byte(name+"\n"), 0644))
+		when := now.AddDate(0, 0, -days)
+		assert.NoError(t, os.Chtimes(fullpath, when, when))
+	}
+	return dir
+}
+
+func TestRunJanitor_compressAndDelete(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.Local)
+	janitorNow = func() time.Time { return now }
+	defer func() { janitorNow = time.Now }()
+
+	config = createDefaultConfig()
+	config.DoneFolder = janitorFolder(t, now, map[string]int{"new.csv": 0, "old.csv": 3, "a/ancient.csv": 10})
+	defer os.RemoveAll(config.DoneFolder)
+	config.Retention.CompressAfterDays = 2
+	config.Retention.DeleteAfterDays = 7
+
+	config.Retention.DryRun = true
+	runJanitor()
+	assert.FileExists(t, filepath.Join(config.DoneFolder, "old.csv"))
+	assert.FileExists(t, filepath.Join(config.DoneFolder, "a", "ancient.csv"))
+
+	config.Retention.DryRun = false
+	runJanitor()
+	assert.FileExists(t, filepath.Join(config.DoneFolder, "new.csv"))
+	assert.NoFileExists(t, filepath.Join(config.DoneFolder, "old.csv"))
+	assert.NoDirExists(t, filepath.Join(config.DoneFolder, "a"))
+
+	f, err := os.Open(filepath.Join(config.DoneFolder, "old.csv.gz"))
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, "old.csv\n", string(data))
+	info, err := f.Stat()
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(now.AddDate(0, 0, -3)))
+}
+
+func TestRunJanitor_capSize(t *testing.T) {
+	now := time.Now()
+	config = createDefaultConfig()
+	config.DoneFolder = janitorFolder(t, now, map[string]int{"a.csv": 1, "b.csv": 2, "c.csv": 3})
+	defer os.RemoveAll(config.DoneFolder)
+
+	//each file is 6 bytes, so only the newest fits
+	capSize(listJanitorFiles(config.DoneFolder), 10)
+	assert.FileExists(t, filepath.Join(config.DoneFolder, "a.csv"))
+	assert.NoFileExists(t, filepath.Join(config.DoneFolder, "b.csv"))
+	assert.NoFileExists(t, filepath.Join(config.DoneFolder, "c.csv"))
+}
+
+func TestRunJanitor_bundle(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.Local)
+	janitorNow = func() time.Time { return now }
+	defer func() { janitorNow = time.Now }()
+
+	config = createDefaultConfig()
+	config.DoneFolder = janitorFolder(t, now, map[string]int{"today.csv": 0, "x.csv": 1, "sub/y.csv": 1})
+	defer os.RemoveAll(config.DoneFolder)
+	config.Retention.Bundle = true
+	runJanitor()
+
+	assert.FileExists(t, filepath.Join(config.DoneFolder, "today.csv"))
+	assert.NoFileExists(t, filepath.Join(config.DoneFolder, "x.csv"))
+	bundle := filepath.Join(config.DoneFolder, "saucepan_2020-06-14.tar.gz")
+	f, err := os.Open(bundle)
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	names := make([]string, 0)
+	var manifest bundleManifest
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == "manifest.json" {
+			data, err := ioutil.ReadAll(tr)
+			assert.NoError(t, err)
+			assert.NoError(t, json.Unmarshal(data, &manifest))
+		}
+	}
+	assert.ElementsMatch(t, []string{"x.csv", "sub/y.csv", "manifest.json"}, names)
+	assert.Equal(t, "2020-06-14", manifest.Day)
+	if assert.Len(t, manifest.Files, 2) {
+		assert.Len(t, manifest.Files[0].SHA256, 64)
+	}
+
+	//the bundle itself is left alone on the next run
+	runJanitor()
+	assert.FileExists(t, bundle)
+	assert.NoFileExists(t, filepath.Join(config.DoneFolder, "saucepan_2020-06-14_1.tar.gz"))
+
+	//a file that turns up for a day already bundled goes in a second bundle, which is then left alone too
+	late := filepath.Join(config.DoneFolder, "late.csv")
+	assert.NoError(t, ioutil.WriteFile(late, []byte("late\n"), 0644))
+	when := now.AddDate(0, 0, -1)
+	assert.NoError(t, os.Chtimes(late, when, when))
+	config.Move.Collision = "overwrite"
+	runJanitor()
+	second := filepath.Join(config.DoneFolder, "saucepan_2020-06-14_1.tar.gz")
+	assert.FileExists(t, bundle)
+	assert.FileExists(t, second)
+	runJanitor()
+	assert.FileExists(t, second)
+	assert.NoFileExists(t, filepath.Join(config.DoneFolder, "saucepan_2020-06-14_2.tar.gz"))
+}
+
+func TestBundleDays_dryRun(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.Local)
+	config = createDefaultConfig()
+	config.DoneFolder = janitorFolder(t, now, map[string]int{"today.csv": 0, "x.csv": 1, "old.csv": 10})
+	defer os.RemoveAll(config.DoneFolder)
+	config.Retention.Bundle = true
+	config.Retention.DryRun = true
+
+	//nothing is bundled, so every file is still there for the compress, delete and cap passes to report
+	files := listJanitorFiles(config.DoneFolder)
+	kept := bundleDays(config.DoneFolder, files, now)
+	assert.ElementsMatch(t, files, kept)
+	assert.FileExists(t, filepath.Join(config.DoneFolder, "x.csv"))
+	assert.NoFileExists(t, filepath.Join(config.DoneFolder, "saucepan_2020-06-14.tar.gz"))
+}
+
+func TestIsBundle(t *testing.T) {
+	root := "done"
+	assert.True(t, isBundle(root, filepath.Join(root, "saucepan_2020-06-14.tar.gz")))
+	assert.True(t, isBundle(root, filepath.Join(root, "saucepan_2020-06-14_2.tar.gz")))
+	assert.True(t, isBundle(root, filepath.Join(root, "saucepan_2020-06-14.tar_1.gz")))
+	assert.False(t, isBundle(root, filepath.Join(root, "sub", "saucepan_2020-06-14.tar.gz")))
+	assert.False(t, isBundle(root, filepath.Join(root, "saucepan_input.csv.tar.gz")))
+	assert.False(t, isBundle(root, filepath.Join(root, "saucepan_2020-06-14.csv")))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type moveConfig struct {
+	PreserveSubfolders bool   `json:"PreserveSubfolders"`
+	Collision          string `json:"Collision"`
+}
+
+//moveLock - held while a free name is picked and taken, so two files can't pick the same one
+var moveLock sync.Mutex
+
+//donePath - where a processed file goes under folder, keeping its path relative to the WatchFolder
+func donePath(folder string, source string) string {
+	if config.Move.PreserveSubfolders {
+		rel, err := filepath.Rel(config.WatchFolder, source)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel) {
+			return filepath.Join(folder, rel)
+		}
+	}
+	return filepath.Join(folder, filepath.Base(source))
+}
+
+func pathExists(fullpath string) bool {
+	_, err := os.Lstat(fullpath)
+	return err == nil
+}
+
+//freeName - dst, or when something is already there a name that isn't taken: "name_1.csv" for the
+//"suffix" Collision mode, "name_{hash}.csv" (then suffixed) for "hash"; "overwrite" always uses dst
+func freeName(src string, dst string) string {
+	if config.Move.Collision == "overwrite" || !pathExists(dst) {
+		return dst
+	}
+	ext := filepath.Ext(dst)
+	base := strings.TrimSuffix(dst, ext)
+	if config.Move.Collision == "hash" {
+		if hash, _, err := hashFile(src); err == nil {
+			base += "_" + hash[:8]
+			if !pathExists(base + ext) {
+				return base + ext
+			}
+		}
+	}
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s_%d%s", base, i, ext)
+		if !pathExists(candidate) {
+			return candidate
+		}
+	}
+}
+
+//isCrossDeviceError - true if a rename failed because the two paths are on different filesystems
+func isCrossDeviceError(err error) bool {
+	if le, ok := err.(*os.LinkError); ok {
+		err = le.Err
+	}
+	errno, ok := err.(syscall.Errno)
+	if !ok {
+		return false
+	}
+	//ERROR_NOT_SAME_DEVICE
+	return errno == syscall.EXDEV || (runtime.GOOS == "windows" && errno == 17)
+}
+
+//moveFile - moves src to dst, creating the folders it needs and picking a free name if dst is taken.
+//Across filesystems the file is copied, the copy checked against the original, then the original removed.
+//Returns where the file ended up
+func moveFile(src string, dst string) (string, error) {
+	if samePath(src, dst) {
+		return dst, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return src, err
+	}
+
+	moveLock.Lock()
+	defer moveLock.Unlock()
+	dst = freeName(src, dst)
+	err := os.Rename(src, dst)
+	if err == nil || !isCrossDeviceError(err) {
+		if err != nil {
+			return src, err
+		}
+		return dst, nil
+	}
+
+	log.WithFields(log.Fields{"src": src, "dst": dst}).Debug("Moving File across filesystems")
+	if err := copyVerified(src, dst); err != nil {
+		return src, err
+	}
+	if err := os.Remove(src); err != nil {
+		log.WithError(err).WithFields(log.Fields{"src": src, "dst": dst}).Warn("File copied but the original could not be removed")
+	}
+	return dst, nil
+}
+
+//moveDoneFile - moveFile for a processed file, which is then dated from when it got to the DoneFolder
+//(not from when it was written), so the Retention janitor ages it from there
+func moveDoneFile(src string, dst string) (string, error) {
+	dst, err := moveFile(src, dst)
+	if err != nil {
+		return dst, err
+	}
+	now := time.Now()
+	if err := os.Chtimes(dst, now, now); err != nil {
+		log.WithError(err).WithField("File", dst).Warn("Unable to set the modified time of a processed file")
+	}
+	return dst, nil
+}
+
+//copyVerified - copies src to a temporary name next to dst, checks the contents match, then renames it into place
+func copyVerified(src string, dst string) error {
+	tmp := dst + ".saucepan-tmp"
+	if err := Copy(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	srcHash, srcSize, err := hashFile(src)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	dstHash, dstSize, err := hashFile(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if srcHash != dstHash || srcSize != dstSize {
+		os.Remove(tmp)
+		return fmt.Errorf("copy of %s does not match the original", src)
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
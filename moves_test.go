@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDonePath(t *testing.T) {
+	config = createDefaultConfig()
+	config.WatchFolder = filepath.Join("in")
+	done := filepath.Join("out")
+
+	assert.Equal(t, filepath.Join(done, "a", "b", "x.csv"), donePath(done, filepath.Join("in", "a", "b", "x.csv")))
+	assert.Equal(t, filepath.Join(done, "x.csv"), donePath(done, filepath.Join("in", "x.csv")))
+	//files from outside the WatchFolder (e.g. a retry from the FailedFolder) keep just their name
+	assert.Equal(t, filepath.Join(done, "x.csv"), donePath(done, filepath.Join("failed", "x.csv")))
+
+	config.Move.PreserveSubfolders = false
+	assert.Equal(t, filepath.Join(done, "x.csv"), donePath(done, filepath.Join("in", "a", "b", "x.csv")))
+}
+
+func TestMoveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "saucepan_move_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config = createDefaultConfig()
+
+	write := func(name string, content string) string {
+		fullpath := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(fullpath, []byte(content), 0644))
+		return fullpath
+	}
+	dst := filepath.Join(dir, "done", "sub", "x.csv")
+
+	moved, err := moveFile(write("x.csv", "one"), dst)
+	assert.NoError(t, err)
+	assert.Equal(t, dst, moved)
+
+	moved, err = moveFile(write("x.csv", "two"), dst)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "done", "sub", "x_1.csv"), moved)
+
+	config.Move.Collision = "hash"
+	src := write("x.csv", "three")
+	hash, _, err := hashFile(src)
+	assert.NoError(t, err)
+	moved, err = moveFile(src, dst)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "done", "sub", "x_"+hash[:8]+".csv"), moved)
+
+	config.Move.Collision = "overwrite"
+	moved, err = moveFile(write("x.csv", "four"), dst)
+	assert.NoError(t, err)
+	assert.Equal(t, dst, moved)
+	data, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "four", string(data))
+}
+
+func TestCopyVerified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "saucepan_move_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "x.csv")
+	dst := filepath.Join(dir, "y.csv")
+	assert.NoError(t, ioutil.WriteFile(src, []byte("a,b\n"), 0644))
+	assert.NoError(t, copyVerified(src, dst))
+	data, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "a,b\n", string(data))
+	assert.NoFileExists(t, dst+".saucepan-tmp")
+
+	assert.Error(t, copyVerified(filepath.Join(dir, "missing.csv"), dst))
+}
+
+func TestIsCrossDeviceError(t *testing.T) {
+	assert.True(t, isCrossDeviceError(&os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.EXDEV}))
+	assert.False(t, isCrossDeviceError(&os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.ENOENT}))
+	assert.False(t, isCrossDeviceError(os.ErrNotExist))
+}
+
+func TestFileHandler_subfolders(t *testing.T) {
+	watch, err := ioutil.TempDir("", "saucepan_input_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(watch)
+	done, err := ioutil.TempDir("", "saucepan_output_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(done)
+
+	config = createDefaultConfig()
+	config.WatchFolder = watch
+	config.DoneFolder = done
+	config.Summary.Enabled = true
+
+	for _, sub := range []string{"a", "b"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(watch, sub), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(watch, sub, "x_1.csv"), []byte(sub+"\n"), 0644))
+		fileHandler(filepath.Join(watch, sub, "x_1.csv"))
+	}
+
+	for _, sub := range []string{"a", "b"} {
+		data, err := ioutil.ReadFile(filepath.Join(done, sub, "x_1.csv"))
+		assert.NoError(t, err)
+		assert.Equal(t, sub+"\n", string(data))
+		assert.FileExists(t, filepath.Join(done, sub, "x_1.csv.summary.json"))
+	}
+}
+
+func TestMoveDoneFile(t *testing.T) {
+	folder, err := ioutil.TempDir("", "saucepan_move_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(folder)
+	config = createDefaultConfig()
+
+	src := filepath.Join(folder, "in", "x.csv")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(src), 0755))
+	assert.NoError(t, ioutil.WriteFile(src, []byte("x\n"), 0644))
+	old := time.Now().AddDate(0, 0, -30)
+	assert.NoError(t, os.Chtimes(src, old, old))
+
+	//the file ages from when it was processed, not from when it was written
+	dst, err := moveDoneFile(src, filepath.Join(folder, "done", "x.csv"))
+	assert.NoError(t, err)
+	info, err := os.Stat(dst)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+}
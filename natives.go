@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//nativeRecipe - a Go-native stand-in for a CyberChef recipe, returns every value it found
+type nativeRecipe func(input string) []string
+
+var (
+	ipv4Regex   = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Regex   = regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`)
+	urlRegex    = regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.\-]*://[^\s"'<>]+`)
+	domainRegex = regexp.MustCompile(`\b(?:[A-Za-z0-9](?:[A-Za-z0-9\-]{0,61}[A-Za-z0-9])?\.)+[A-Za-z]{2,63}\b`)
+	emailRegex  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@(?:[A-Za-z0-9\-]+\.)+[A-Za-z]{2,63}`)
+	hashRegex   = regexp.MustCompile(`\b[A-Fa-f0-9]{32,128}\b`)
+	base64Regex = regexp.MustCompile(`[A-Za-z0-9+/]{16,}={0,2}`)
+	hexRegex    = regexp.MustCompile(`\b(?:[0-9A-Fa-f]{2}){8,}\b`)
+
+	nativeRecipes = map[string]nativeRecipe{
+		"Extract IP addresses":    extractIPs,
+		"Extract URLs":            extractURLs,
+		"Extract domains":         extractDomains,
+		"Extract email addresses": extractEmails,
+		"Extract hashes":          extractHashes,
+		"From Base64":             decodeBase64,
+		"URL Decode":              decodeURL,
+		"From Hex":                decodeHex,
+	}
+
+	//keeps results in a stable order
+	nativeRecipeOrder = []string{
+		"Extract IP addresses",
+		"Extract URLs",
+		"Extract domains",
+		"Extract email addresses",
+		"Extract hashes",
+		"From Base64",
+		"URL Decode",
+		"From Hex",
+	}
+)
+
+func validateNativeRecipes(cfg nativeConfig) {
+	switch cfg.Mode {
+	case "", "fallback", "alongside":
+	default:
+		log.WithField("Mode", cfg.Mode).Fatal("Invalid NativeRecipes.Mode, must be fallback or alongside")
+	}
+	for _, name := range cfg.Recipes {
+		if _, ok := nativeRecipes[name]; !ok {
+			log.WithField("Recipe", name).Fatalf("Unknown native recipe, must be one of %q", nativeRecipeOrder)
+		}
+	}
+}
+
+func runNativeRecipes(input string) []map[string]interface{} {
+	names := config.NativeRecipes.Recipes
+	if len(names) == 0 {
+		names = nativeRecipeOrder
+	}
+
+	ans := make([]map[string]interface{}, 0)
+	for _, name := range names {
+		recipe, ok := nativeRecipes[name]
+		if !ok {
+			continue
+		}
+		found := recipe(input)
+		if len(found) > 0 {
+			ans = append(ans, map[string]interface{}{
+				"recipeName": name,
+				"result":     strings.Join(found, "\n"),
+			})
+		}
+	}
+	return ans
+}
+
+func uniqueStrings(input []string) []string {
+	seen := make(map[string]bool)
+	ans := make([]string, 0)
+	for _, s := range input {
+		if !seen[s] {
+			seen[s] = true
+			ans = append(ans, s)
+		}
+	}
+	return ans
+}
+
+func isPrintable(s string) bool {
+	if !utf8.ValidString(s) || len(s) == 0 {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func extractIPs(input string) []string {
+	ans := make([]string, 0)
+	for _, candidate := range ipv4Regex.FindAllString(input, -1) {
+		if net.ParseIP(candidate) != nil {
+			ans = append(ans, candidate)
+		}
+	}
+	for _, candidate := range ipv6Regex.FindAllString(input, -1) {
+		if strings.Count(candidate, ":") >= 2 && net.ParseIP(candidate) != nil {
+			ans = append(ans, candidate)
+		}
+	}
+	return uniqueStrings(ans)
+}
+
+func extractURLs(input string) []string {
+	return uniqueStrings(urlRegex.FindAllString(input, -1))
+}
+
+func extractDomains(input string) []string {
+	ans := make([]string, 0)
+	for _, candidate := range domainRegex.FindAllString(input, -1) {
+		//skip dotted quads, they are IPs not domains
+		if net.ParseIP(candidate) == nil {
+			ans = append(ans, candidate)
+		}
+	}
+	return uniqueStrings(ans)
+}
+
+func extractEmails(input string) []string {
+	return uniqueStrings(emailRegex.FindAllString(input, -1))
+}
+
+func extractHashes(input string) []string {
+	ans := make([]string, 0)
+	for _, candidate := range hashRegex.FindAllString(input, -1) {
+		switch len(candidate) {
+		case 32, 40, 64, 128: //MD5, SHA1, SHA256, SHA512
+			ans = append(ans, candidate)
+		}
+	}
+	return uniqueStrings(ans)
+}
+
+func decodeBase64(input string) []string {
+	ans := make([]string, 0)
+	for _, candidate := range base64Regex.FindAllString(input, -1) {
+		decoded, err := base64.StdEncoding.DecodeString(candidate)
+		if err != nil {
+			continue
+		}
+		if s := string(decoded); isPrintable(s) {
+			ans = append(ans, s)
+		}
+	}
+	return uniqueStrings(ans)
+}
+
+func decodeURL(input string) []string {
+	if !strings.Contains(input, "%") {
+		return nil
+	}
+	decoded, err := url.QueryUnescape(input)
+	if err != nil || decoded == input {
+		return nil
+	}
+	return []string{decoded}
+}
+
+func decodeHex(input string) []string {
+	ans := make([]string, 0)
+	for _, candidate := range hexRegex.FindAllString(input, -1) {
+		decoded, err := hex.DecodeString(candidate)
+		if err != nil {
+			continue
+		}
+		if s := string(decoded); isPrintable(s) {
+			ans = append(ans, s)
+		}
+	}
+	return uniqueStrings(ans)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNativeExtractors(t *testing.T) {
+	input := "GET http://evil.example.com/a?b=c from 10.1.2.3 to fe80::1 by bob@example.org d41d8cd98f00b204e9800998ecf8427e"
+
+	assert.Equal(t, []string{"10.1.2.3", "fe80::1"}, extractIPs(input))
+	assert.Equal(t, []string{"http://evil.example.com/a?b=c"}, extractURLs(input))
+	assert.Equal(t, []string{"evil.example.com", "example.org"}, extractDomains(input))
+	assert.Equal(t, []string{"bob@example.org"}, extractEmails(input))
+	assert.Equal(t, []string{"d41d8cd98f00b204e9800998ecf8427e"}, extractHashes(input))
+}
+
+func TestNativeDecoders(t *testing.T) {
+	assert.Equal(t, []string{"powershell -enc foo"}, decodeBase64("x=cG93ZXJzaGVsbCAtZW5jIGZvbw=="))
+	assert.Equal(t, []string{"a=<script>"}, decodeURL("a=%3Cscript%3E"))
+	assert.Empty(t, decodeURL("nothing to see"))
+	assert.Equal(t, []string{"cmd.exe /c"}, decodeHex("636d642e657865202f63"))
+	assert.Empty(t, decodeHex("d41d8cd98f00b204e9800998ecf8427e"))
+}
+
+func TestGetSauce_nativeFallback(t *testing.T) {
+	config = createDefaultConfig()
+	config.CyberSaucier.Enabled = true
+	config.CyberSaucier.URL = "http://127.0.0.1:1"
+	config.NativeRecipes.Enabled = true
+	config.NativeRecipes.Recipes = []string{"Extract IP addresses"}
+
+	//the fallback covers for CyberSaucier, so it isn't a failure
+	results, err := getSauce(config.defaultProfile(), "connect to 192.168.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"recipeName": "Extract IP addresses", "result": "192.168.1.1"},
+	}, results)
+
+	//alongside, CyberSaucier's results are still missing
+	config.NativeRecipes.Mode = "alongside"
+	results, err = getSauce(config.defaultProfile(), "connect to 192.168.1.1")
+	assert.Error(t, err)
+	assert.Len(t, results, 1)
+}
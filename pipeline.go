@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//fileRun - everything collected while the records of one file go through parsing, CyberSaucier and the outputs
+type fileRun struct {
+	fullpath     string
+	filename     string
+	tag          string
+	dtStamp      string
+	prof         *profileConfig
+	headers      []string
+	comma        rune
+	nojuice      [][]string
+	parseerrors  []SauceParseError
+	ruleCounts   ruleCounter
+	summary      *ingestSummary
+	sampler      *fileSampler
+	outputFailed bool
+	failures     []string
+	dedupKeys    []string
+}
+
+func newFileRun(fullpath string, prof *profileConfig, headers []string, comma rune) *fileRun {
+	filename, tag, dtStamp := parseFileName(fullpath)
+	summary := newIngestSummary("file")
+	summary.File = fullpath
+	summary.Profile = prof.Name
+	summary.Tag = tag
+
+	return &fileRun{
+		fullpath:    fullpath,
+		filename:    filename,
+		tag:         tag,
+		dtStamp:     dtStamp,
+		prof:        prof,
+		headers:     headers,
+		comma:       comma,
+		nojuice:     make([][]string, 0),
+		parseerrors: make([]SauceParseError, 0),
+		ruleCounts:  make(ruleCounter),
+		summary:     summary,
+		sampler:     newFileSampler(filename, tag),
+	}
+}
+
+//processRecord - parses, sauces, enriches and sends (or sets aside) one CSV record
+func (r *fileRun) processRecord(line int, record []string, err error) {
+	r.summary.Rows++
+
+	if err != nil {
+		if pe, ok := err.(*csv.ParseError); ok {
+			if pe.Err != csv.ErrFieldCount {
+				spe := SauceParseError{
+					File:       r.fullpath,
+					Line:       line,
+					Column:     pe.Column,
+					ErrMessage: redactText(pe.Err.Error()),
+					Raw:        redactText(strings.Join(redactRow(r.headers, record), string(r.comma))),
+				}
+				r.parseerrors = append(r.parseerrors, spe)
+				r.summary.ParseErrors++
+			}
+		} else {
+			log.WithError(err).Warn("Could not read record")
+			return
+		}
+	}
+
+	obj, captures := parseLine(r.prof, r.filename, line, r.tag, r.dtStamp, r.headers, record)
+
+	//Drop records already seen in the dedup window
+	dup, key := isDuplicateRecord(obj, record)
+	if dup {
+		r.summary.Duplicates++
+		return
+	}
+	if key != "" {
+		r.dedupKeys = append(r.dedupKeys, key)
+	}
+
+	//Record-level rules
+	rules := evaluateRules(obj, r.ruleCounts)
+	if rules.Drop {
+		r.summary.Dropped++
+		return
+	}
+
+	//Send to CyberSaucier
+	sauced := config.CyberSaucier.Enabled || config.NativeRecipes.Enabled
+	juice := false
+	cybers := make([]map[string]interface{}, 0)
+	if sauced && !rules.SkipSauce {
+		for _, capture := range captures {
+			sauceStart := time.Now()
+			results, err := getSauce(r.prof, capture.Value)
+			r.summary.addSauceCall(time.Since(sauceStart), err)
+			if err != nil {
+				log.WithError(err).Warn("Error in CyberSaucier")
+				r.fail("CyberSaucier", err)
+			}
+			for _, result := range results {
+				result["column"] = capture.Column
+			}
+			cybers = append(cybers, results...)
+		}
+
+		//Append CyberSaucier results to obj, only push if there are hits
+		juice = addSauce(obj, cybers)
+	}
+
+	//Threat intel matches are juice on their own
+	if matchThreatIntel(obj) {
+		juice = true
+	}
+
+	//Push the juice (and records forced by a rule), or everything (less any sampled out) if CyberSaucier is disabled
+	if !juice && !rules.Index && !sauced && !r.sampler.keep(line, record) {
+		r.summary.Sampled++
+	} else if juice || !sauced || rules.Index {
+		enrichRecord(obj)
+		redactRecord(obj)
+		log.WithFields(log.Fields{"Obj": obj}).Trace("Juice")
+		if juice {
+			r.summary.addJuice(obj)
+		}
+		//Send to ES
+		err = sendDataToES(r.fullpath, r.prof.IndexStart, obj)
+		if err != nil {
+			if !r.outputFailed {
+				log.WithError(err).Warn("Error sending to ElasticSearch")
+			}
+			r.outputFailed = true
+			r.fail("ElasticSearch", err)
+		}
+	} else {
+		r.summary.NoSauce++
+		record = redactRow(r.headers, record)
+		log.WithFields(log.Fields{"Record": record}).Trace("No Juice")
+		if config.SaveNoSauce {
+			r.nojuice = append(r.nojuice, record)
+		}
+	}
+}
+
+//maxFailures - how many distinct failure reasons are kept for a file
+const maxFailures = 10
+
+//fail - records why the file did not fully make it through, each distinct reason once
+func (r *fileRun) fail(stage string, err error) {
+	reason := stage + ": " + err.Error()
+	for _, f := range r.failures {
+		if f == reason {
+			return
+		}
+	}
+	if len(r.failures) < maxFailures {
+		r.failures = append(r.failures, reason)
+	}
+}
+
+//failed - true if any record hit an output or enrichment failure
+func (r *fileRun) failed() bool {
+	return len(r.failures) > 0
+}
+
+//settleDedup - once the outputs have (or have not) taken the records so far: the dedup keys of records that
+//were sent are kept, those of a failed run are forgotten so a retry (or re-read) isn't dropped as duplicates
+func (r *fileRun) settleDedup(failed bool) {
+	if failed {
+		forgetDedupKeys(r.dedupKeys)
+	}
+	r.dedupKeys = nil
+}
+
+//settleRows - once the outputs have (or have not) taken the records so far: the NoSauce rows and parse errors
+//held for them are written, or dropped when the same lines will be read again (a retry, resume or re-poll)
+func (r *fileRun) settleRows(reread bool) {
+	if !reread {
+		r.writeParseErrors()
+		r.writeNoSauce()
+	}
+	r.nojuice = make([][]string, 0)
+	r.parseerrors = make([]SauceParseError, 0)
+}
+
+//writeParseErrors - appends the parse errors collected so far to the ParseErrorFile
+func (r *fileRun) writeParseErrors() {
+	if len(r.parseerrors) > 0 {
+		parseerrorfile := config.GetMacrod("ParseErrorFile")
+		outFile := path.Join(config.DoneFolder, parseerrorfile)
+		oFile, err := os.OpenFile(outFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.WithError(err).Warn("Error opening ParseErrorFile")
+		} else {
+			defer oFile.Close()
+			for _, e := range r.parseerrors {
+				errorJSON, err := json.Marshal(e)
+				if err != nil {
+					log.WithError(err).Warn("Error marshalling parseerror to json")
+				} else {
+					_, err = io.WriteString(oFile, string(errorJSON)+"\n")
+					if err != nil {
+						log.WithError(err).Warn("Error writing to ParseErrorFile")
+					}
+				}
+			}
+		}
+		r.parseerrors = make([]SauceParseError, 0)
+	}
+}
+
+//writeNoSauce - appends the "NoSauce" records collected so far to the NoSauceFile
+func (r *fileRun) writeNoSauce() {
+	if config.SaveNoSauce && len(r.nojuice) > 0 {
+		nosaucefile := config.GetMacrod("NoSauceFile")
+
+		outFile := path.Join(config.DoneFolder, nosaucefile)
+		oFile, err := os.OpenFile(outFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.WithError(err).Warn("Error opening NoSauceFile")
+		} else {
+			defer oFile.Close()
+			writer := csv.NewWriter(oFile)
+			err := writer.WriteAll(r.nojuice)
+			if err != nil {
+				log.WithError(err).Warn("Failure writing to NoSauceFile")
+			}
+
+			if err := writer.Error(); err != nil {
+				log.WithError(err).Warn("Failure writing to NoSauceFile")
+			}
+		}
+		r.nojuice = make([][]string, 0)
+	}
+}
+
+//complete - logs the file's counts and writes its summary next to outPath, where the processed file went
+func (r *fileRun) complete(outPath string) {
+	saveDedup()
+	logRuleCounts(r.fullpath, r.ruleCounts)
+	writeFileSummary(r.summary, filepath.Dir(outPath), filepath.Base(outPath))
+	log.WithFields(log.Fields{
+		"File":        r.fullpath,
+		"Rows":        r.summary.Rows,
+		"Juice":       r.summary.Juice,
+		"NoSauce":     r.summary.NoSauce,
+		"ParseErrors": r.summary.ParseErrors,
+		"Duplicates":  r.summary.Duplicates,
+		"Sampled":     r.summary.Sampled,
+	}).Info("File Processing Complete")
+}
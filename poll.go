@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+//pollEntry - what a path looked like at the last poll
+type pollEntry struct {
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+//pollWatcher - diffs directory listings every interval and emits the same events fsnotify would,
+//for network mounts (NFS/SMB) where fsnotify gets no events
+type pollWatcher struct {
+	root     string
+	interval time.Duration
+	snapshot map[string]pollEntry
+	Events   chan fsnotify.Event
+	Errors   chan error
+	done     chan struct{}
+}
+
+func newPollWatcher(root string, interval time.Duration) *pollWatcher {
+	p := &pollWatcher{
+		root:     root,
+		interval: interval,
+		Events:   make(chan fsnotify.Event, 100),
+		Errors:   make(chan error, 10),
+		done:     make(chan struct{}),
+	}
+	p.snapshot = p.list()
+	go p.loop()
+	return p
+}
+
+//list - walks the whole tree below root, leaving out the DoneFolder and FailedFolder
+func (p *pollWatcher) list() map[string]pollEntry {
+	entries := make(map[string]pollEntry)
+	err := filepath.Walk(p.root, func(fullpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.WithError(err).WithField("Path", fullpath).Debug("Error polling folder")
+			return nil
+		}
+		if fullpath == p.root {
+			return nil
+		}
+		if info.IsDir() && isOutputFolder(fullpath) {
+			return filepath.SkipDir
+		}
+		entries[fullpath] = pollEntry{Size: info.Size(), ModTime: info.ModTime(), IsDir: info.IsDir()}
+		return nil
+	})
+	if err != nil {
+		select {
+		case p.Errors <- err:
+		default:
+		}
+	}
+	return entries
+}
+
+//scan - lists the tree again and returns the changes since the last scan, parents before children
+func (p *pollWatcher) scan() []fsnotify.Event {
+	current := p.list()
+	events := make([]fsnotify.Event, 0)
+
+	for fullpath, entry := range current {
+		old, ok := p.snapshot[fullpath]
+		if !ok {
+			events = append(events, fsnotify.Event{Name: fullpath, Op: fsnotify.Create})
+		} else if !entry.IsDir && (old.Size != entry.Size || !old.ModTime.Equal(entry.ModTime)) {
+			events = append(events, fsnotify.Event{Name: fullpath, Op: fsnotify.Write})
+		}
+	}
+	for fullpath := range p.snapshot {
+		if _, ok := current[fullpath]; !ok {
+			events = append(events, fsnotify.Event{Name: fullpath, Op: fsnotify.Remove})
+		}
+	}
+	p.snapshot = current
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Name < events[j].Name
+	})
+	return events
+}
+
+func (p *pollWatcher) loop() {
+	defer close(p.Events)
+	defer close(p.Errors)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			for _, event := range p.scan() {
+				select {
+				case p.Events <- event:
+				case <-p.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+//Add - every folder below root is already polled
+func (p *pollWatcher) Add(name string) error {
+	return nil
+}
+
+//Remove - every folder below root is already polled
+func (p *pollWatcher) Remove(name string) error {
+	return nil
+}
+
+func (p *pollWatcher) Close() error {
+	close(p.done)
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollWatcher_scan(t *testing.T) {
+	root, err := ioutil.TempDir("", "saucepan_poll_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	config = createDefaultConfig()
+	config.DoneFolder = filepath.Join(root, "done")
+	assert.NoError(t, os.Mkdir(config.DoneFolder, 0755))
+	existing := filepath.Join(root, "a_1.csv")
+	assert.NoError(t, ioutil.WriteFile(existing, []byte("a\n"), 0644))
+
+	p := newPollWatcher(root, time.Hour)
+	defer p.Close()
+	assert.Empty(t, p.scan())
+
+	sub := filepath.Join(root, "sub")
+	assert.NoError(t, os.Mkdir(sub, 0755))
+	created := filepath.Join(sub, "b_1.csv")
+	assert.NoError(t, ioutil.WriteFile(created, []byte("b\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(config.DoneFolder, "c_1.csv"), []byte("c\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(existing, []byte("a\na\n"), 0644))
+
+	assert.Equal(t, []fsnotify.Event{
+		{Name: existing, Op: fsnotify.Write},
+		{Name: sub, Op: fsnotify.Create},
+		{Name: created, Op: fsnotify.Create},
+	}, p.scan())
+
+	assert.NoError(t, os.RemoveAll(sub))
+	assert.Equal(t, []fsnotify.Event{
+		{Name: sub, Op: fsnotify.Remove},
+		{Name: created, Op: fsnotify.Remove},
+	}, p.scan())
+}
+
+func TestFolderWatcher_poll(t *testing.T) {
+	root, err := ioutil.TempDir("", "saucepan_poll_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	config = createDefaultConfig()
+	config.WatchFolder = root
+	config.WatchMode = "poll"
+
+	w, err := newFolderWatcher(root)
+	assert.NoError(t, err)
+	assert.Equal(t, "poll", w.mode)
+	w.watcher.Close()
+
+	//a faster poller than PollInterval allows, for the test
+	poller := newPollWatcher(root, time.Millisecond*10)
+	w.watcher = poller
+	w.events = poller.Events
+	w.errors = poller.Errors
+	files := make(chan string, 10)
+	w.onFile = func(fullpath string) {
+		files <- fullpath
+		w.done(fullpath)
+	}
+	//wait for the watcher to stop, so it isn't still reading config during the next test
+	stopped := make(chan struct{})
+	go func() {
+		w.run()
+		close(stopped)
+	}()
+	defer func() {
+		w.close()
+		<-stopped
+	}()
+
+	newFile := filepath.Join(root, "new", "a_1.csv")
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "new"), 0755))
+	assert.NoError(t, ioutil.WriteFile(newFile, []byte("a\n"), 0644))
+	waitForFile(t, files, newFile)
+}
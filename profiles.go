@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//profileConfig - the ingestion settings for one feed, chosen by the file's name or folder
+//Any setting left out of a profile is inherited from the top-level configuration
+type profileConfig struct {
+	Name              string            `json:"Name"`
+	FileGlob          string            `json:"FileGlob"`
+	FileRegex         string            `json:"FileRegex"`
+	Folder            string            `json:"Folder"`
+	CSVOptions        *csvconfig        `json:"CSVOptions"`
+	ExtraParsing      []extraparsing    `json:"ExtraParsing"`
+	SubParsers        []subparserConfig `json:"SubParsers"`
+	Schema            []fieldSchema     `json:"Schema"`
+	CyberSaucierQuery *string           `json:"CyberSaucierQuery"`
+	IndexStart        string            `json:"IndexStart"`
+	Readiness         *readinessConfig  `json:"Readiness"`
+	Tail              *tailConfig       `json:"Tail"`
+
+	//csvOptionsSet - the CSVOptions the profile's JSON set (lowercased), so a false or 0 there isn't inherited over
+	csvOptionsSet map[string]bool
+}
+
+//UnmarshalJSON - also records which CSVOptions were set
+func (p *profileConfig) UnmarshalJSON(data []byte) error {
+	type plainProfileConfig profileConfig
+	var plain plainProfileConfig
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	var raw struct {
+		CSVOptions map[string]json.RawMessage `json:"CSVOptions"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*p = profileConfig(plain)
+	if raw.CSVOptions != nil {
+		p.csvOptionsSet = make(map[string]bool)
+		for key := range raw.CSVOptions {
+			p.csvOptionsSet[strings.ToLower(key)] = true
+		}
+	}
+	return nil
+}
+
+//inherit - the profile's CSVOptions, with every option it left out taken from base
+func (c csvconfig) inherit(base *csvconfig, set map[string]bool) *csvconfig {
+	missing := func(name string) bool { return !set[strings.ToLower(name)] }
+	if missing("FirstRowHeader") && !c.FirstRowHeader {
+		c.FirstRowHeader = base.FirstRowHeader
+	}
+	if missing("CaptureColumn") && c.CaptureColumn == 0 {
+		c.CaptureColumn = base.CaptureColumn
+	}
+	if missing("CaptureColumns") && c.CaptureColumns == nil {
+		c.CaptureColumns = base.CaptureColumns
+	}
+	if missing("CaptureMode") && c.CaptureMode == "" {
+		c.CaptureMode = base.CaptureMode
+	}
+	if missing("CaptureSeparator") && c.CaptureSeparator == "" {
+		c.CaptureSeparator = base.CaptureSeparator
+	}
+	if missing("Delimiter") && c.Delimiter == "" {
+		c.Delimiter = base.Delimiter
+	}
+	if missing("Comment") && c.Comment == "" {
+		c.Comment = base.Comment
+	}
+	if missing("Headers") && c.Headers == nil {
+		c.Headers = base.Headers
+	}
+	return &c
+}
+
+//defaultProfile - the profile used when no other profile matches, built from the top-level configuration
+func (c *configuration) defaultProfile() *profileConfig {
+	csvOptions := c.CSVOptions
+	query := c.CyberSaucier.Query
+	readiness := c.Readiness
+	tail := c.Tail
+	return &profileConfig{
+		Name:              "",
+		CSVOptions:        &csvOptions,
+		ExtraParsing:      c.ExtraParsing,
+		SubParsers:        c.SubParsers,
+		Schema:            c.Schema,
+		CyberSaucierQuery: &query,
+		IndexStart:        c.ElasticSearch.IndexStart,
+		Readiness:         &readiness,
+		Tail:              &tail,
+	}
+}
+
+//resolve - returns a copy of the profile with the missing settings filled in from the top-level configuration
+func (p profileConfig) resolve(c *configuration) *profileConfig {
+	base := c.defaultProfile()
+	if p.CSVOptions == nil {
+		p.CSVOptions = base.CSVOptions
+	} else {
+		p.CSVOptions = p.CSVOptions.inherit(base.CSVOptions, p.csvOptionsSet)
+	}
+	if p.ExtraParsing == nil {
+		p.ExtraParsing = base.ExtraParsing
+	}
+	if p.SubParsers == nil {
+		p.SubParsers = base.SubParsers
+	}
+	if p.Schema == nil {
+		p.Schema = base.Schema
+	}
+	if p.CyberSaucierQuery == nil {
+		p.CyberSaucierQuery = base.CyberSaucierQuery
+	}
+	if p.IndexStart == "" {
+		p.IndexStart = base.IndexStart
+	}
+	if p.Readiness == nil {
+		p.Readiness = base.Readiness
+	} else {
+		p.Readiness = p.Readiness.inherit(base.Readiness)
+	}
+	if p.Tail == nil {
+		p.Tail = base.Tail
+	}
+	return &p
+}
+
+//matches - checks the file against the profile's glob, regex and folder, every one that is set must match
+func (p *profileConfig) matches(fullpath string) bool {
+	filename := filepath.Base(fullpath)
+	slashPath := filepath.ToSlash(fullpath)
+
+	if p.FileGlob != "" {
+		if ok, err := filepath.Match(p.FileGlob, filename); err != nil || !ok {
+			return false
+		}
+	}
+
+	if p.FileRegex != "" {
+		re, err := getRegex(p.FileRegex)
+		if err != nil || !re.MatchString(slashPath) {
+			return false
+		}
+	}
+
+	if p.Folder != "" {
+		rel, err := filepath.Rel(config.WatchFolder, filepath.Dir(fullpath))
+		if err != nil {
+			return false
+		}
+		rel = filepath.ToSlash(rel)
+		folder := strings.Trim(filepath.ToSlash(p.Folder), "/")
+		if rel != folder && !strings.HasPrefix(rel, folder+"/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+//selectProfile - finds the first profile that matches the file, falling back to the top-level configuration
+func selectProfile(fullpath string) *profileConfig {
+	for _, p := range config.Profiles {
+		if p.matches(fullpath) {
+			log.WithFields(log.Fields{"File": fullpath, "Profile": p.Name}).Debug("Selected profile")
+			return p.resolve(config)
+		}
+	}
+	return config.defaultProfile()
+}
+
+//findProfile - the profile with the given name, nil if there isn't one
+func findProfile(name string) *profileConfig {
+	for _, p := range config.Profiles {
+		if p.Name == name {
+			return p.resolve(config)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectProfile(t *testing.T) {
+	config = createDefaultConfig()
+	config.WatchFolder = filepath.FromSlash("/data/input")
+	config.ElasticSearch.IndexStart = "default-"
+	config.CSVOptions.CaptureColumn = 6
+
+	err := json.Unmarshal([]byte(`[
+		{"Name": "firewall", "FileGlob": "fw_*.csv", "IndexStart": "firewall-", "CSVOptions": {"CaptureColumn": 2}},
+		{"Name": "proxy", "Folder": "proxy", "FileRegex": "\\.log$", "CyberSaucierQuery": "?match=proxy"},
+		{"Name": "dns", "FileRegex": "(?i)dns"}
+	]`), &config.Profiles)
+	assert.NoError(t, err)
+
+	prof := selectProfile(filepath.FromSlash("/data/input/fw_2019-01-01.csv"))
+	assert.Equal(t, "firewall", prof.Name)
+	assert.Equal(t, "firewall-", prof.IndexStart)
+	assert.Equal(t, 2, prof.CSVOptions.CaptureColumn)
+	assert.Equal(t, "", *prof.CyberSaucierQuery)
+
+	prof = selectProfile(filepath.FromSlash("/data/input/proxy/east/access.log"))
+	assert.Equal(t, "proxy", prof.Name)
+	assert.Equal(t, "default-", prof.IndexStart)
+	assert.Equal(t, 6, prof.CSVOptions.CaptureColumn)
+	assert.Equal(t, "?match=proxy", *prof.CyberSaucierQuery)
+	assert.Equal(t, config.Schema, prof.Schema)
+
+	prof = selectProfile(filepath.FromSlash("/data/input/proxyish/access.log"))
+	assert.Equal(t, "", prof.Name)
+
+	prof = selectProfile(filepath.FromSlash("/data/input/DNS_2019.csv"))
+	assert.Equal(t, "dns", prof.Name)
+
+	prof = selectProfile(filepath.FromSlash("/data/input/other.csv"))
+	assert.Equal(t, "", prof.Name)
+	assert.Equal(t, "default-", prof.IndexStart)
+}
+
+func TestResolve_CSVOptionsMerged(t *testing.T) {
+	config = createDefaultConfig()
+	config.CSVOptions.FirstRowHeader = true
+	config.CSVOptions.CaptureColumn = 6
+	config.CSVOptions.Delimiter = ";"
+
+	err := json.Unmarshal([]byte(`[
+		{"Name": "a", "CSVOptions": {"CaptureColumn": 2}},
+		{"Name": "b", "CSVOptions": {"firstRowHeader": false, "Delimiter": "|"}}
+	]`), &config.Profiles)
+	assert.NoError(t, err)
+
+	prof := config.Profiles[0].resolve(config)
+	assert.Equal(t, 2, prof.CSVOptions.CaptureColumn)
+	assert.True(t, prof.CSVOptions.FirstRowHeader)
+	assert.Equal(t, ";", prof.CSVOptions.Delimiter)
+	assert.Equal(t, "separate", prof.CSVOptions.CaptureMode)
+	assert.Equal(t, " ", prof.CSVOptions.CaptureSeparator)
+
+	//options set in the profile win, even when they are false
+	prof = config.Profiles[1].resolve(config)
+	assert.False(t, prof.CSVOptions.FirstRowHeader)
+	assert.Equal(t, "|", prof.CSVOptions.Delimiter)
+	assert.Equal(t, 6, prof.CSVOptions.CaptureColumn)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type reverseDNSConfig struct {
+	Enabled       bool     `json:"Enabled"`
+	Fields        []string `json:"Fields"`
+	ForwardHits   bool     `json:"ForwardHits"`
+	Resolver      string   `json:"Resolver"`
+	MaxConcurrent int      `json:"MaxConcurrent"`
+	Timeout       int      `json:"Timeout"`
+	CacheTTL      int      `json:"CacheTTL"`
+	NegativeTTL   int      `json:"NegativeTTL"`
+	CacheSize     int      `json:"CacheSize"`
+}
+
+type dnsCacheEntry struct {
+	Key     string
+	Values  []string
+	Expires time.Time
+}
+
+//dnsResolver - a bounded, caching resolver; the lookup functions can be swapped out for testing.
+//The cache is least recently used first in recent, so a full cache only loses its stalest entry
+type dnsResolver struct {
+	lock       sync.Mutex
+	cache      map[string]*list.Element
+	recent     *list.List
+	sem        chan struct{}
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+var rdns *dnsResolver
+
+func newDNSResolver() *dnsResolver {
+	resolver := net.DefaultResolver
+	if config.ReverseDNS.Resolver != "" {
+		address := config.ReverseDNS.Resolver
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, address)
+			},
+		}
+	}
+
+	maxConcurrent := config.ReverseDNS.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	return &dnsResolver{
+		cache:      make(map[string]*list.Element),
+		recent:     list.New(),
+		sem:        make(chan struct{}, maxConcurrent),
+		lookupAddr: resolver.LookupAddr,
+		lookupHost: resolver.LookupHost,
+	}
+}
+
+func initReverseDNS() {
+	if config.ReverseDNS.Enabled {
+		rdns = newDNSResolver()
+	}
+}
+
+func (r *dnsResolver) getCached(key string) ([]string, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	elem, ok := r.cache[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*dnsCacheEntry)
+	if time.Now().After(entry.Expires) {
+		r.recent.Remove(elem)
+		delete(r.cache, key)
+		return nil, false
+	}
+	r.recent.MoveToFront(elem)
+	return entry.Values, true
+}
+
+func (r *dnsResolver) setCached(key string, values []string, ttl int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	expires := time.Now().Add(time.Second * time.Duration(ttl))
+	if elem, ok := r.cache[key]; ok {
+		entry := elem.Value.(*dnsCacheEntry)
+		entry.Values = values
+		entry.Expires = expires
+		r.recent.MoveToFront(elem)
+		return
+	}
+	for config.ReverseDNS.CacheSize > 0 && len(r.cache) >= config.ReverseDNS.CacheSize {
+		oldest := r.recent.Back()
+		r.recent.Remove(oldest)
+		delete(r.cache, oldest.Value.(*dnsCacheEntry).Key)
+	}
+	r.cache[key] = r.recent.PushFront(&dnsCacheEntry{Key: key, Values: values, Expires: expires})
+}
+
+//resolve - looks up the name (through the cache), failed lookups are cached for NegativeTTL seconds
+func (r *dnsResolver) resolve(kind string, name string, lookup func(ctx context.Context, name string) ([]string, error)) []string {
+	cacheKey := kind + ":" + name
+	if values, ok := r.getCached(cacheKey); ok {
+		return values
+	}
+
+	r.sem <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(config.ReverseDNS.Timeout))
+	values, err := lookup(ctx, name)
+	cancel()
+	<-r.sem
+
+	if err != nil || len(values) == 0 {
+		log.WithError(err).WithField("Name", name).Debug("DNS lookup failed")
+		r.setCached(cacheKey, nil, config.ReverseDNS.NegativeTTL)
+		return nil
+	}
+	for i, v := range values {
+		values[i] = strings.TrimSuffix(v, ".")
+	}
+	r.setCached(cacheKey, values, config.ReverseDNS.CacheTTL)
+	return values
+}
+
+//resolveAll - resolves every name concurrently (bounded by MaxConcurrent)
+func (r *dnsResolver) resolveAll(kind string, names []string, lookup func(ctx context.Context, name string) ([]string, error)) map[string][]string {
+	ans := make(map[string][]string)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			values := r.resolve(kind, name, lookup)
+			lock.Lock()
+			ans[name] = values
+			lock.Unlock()
+		}(name)
+	}
+	wg.Wait()
+	return ans
+}
+
+//enrichReverseDNS - adds "rdns" (field, ip, hostnames) entries for the IP fields and, optionally, "dns" (domain, ips) entries for domain hits
+func enrichReverseDNS(obj map[string]interface{}) {
+	if !config.ReverseDNS.Enabled || rdns == nil {
+		return
+	}
+
+	ips := collectIPs(obj, config.ReverseDNS.Fields, false)
+	keys := make([]string, 0, len(ips))
+	for _, item := range ips {
+		keys = append(keys, item.IP.String())
+	}
+	resolved := rdns.resolveAll("ptr", uniqueStrings(keys), rdns.lookupAddr)
+
+	entries := make([]map[string]interface{}, 0)
+	for _, item := range ips {
+		if hostnames := resolved[item.IP.String()]; len(hostnames) > 0 {
+			entries = append(entries, map[string]interface{}{
+				"field":     item.Field,
+				"ip":        item.IP.String(),
+				"hostnames": hostnames,
+			})
+		}
+	}
+	if len(entries) > 0 {
+		obj["rdns"] = entries
+	}
+
+	if config.ReverseDNS.ForwardHits {
+		domains := make([]string, 0)
+		for _, hit := range fieldStrings(obj["Hits"]) {
+			if net.ParseIP(hit) == nil && fullDomainRegex.MatchString(strings.ToLower(hit)) {
+				domains = append(domains, strings.ToLower(hit))
+			}
+		}
+		domains = uniqueStrings(domains)
+		resolved := rdns.resolveAll("host", domains, rdns.lookupHost)
+
+		forward := make([]map[string]interface{}, 0)
+		for _, domain := range domains {
+			if addrs := resolved[domain]; len(addrs) > 0 {
+				forward = append(forward, map[string]interface{}{
+					"domain": domain,
+					"ips":    addrs,
+				})
+			}
+		}
+		if len(forward) > 0 {
+			obj["dns"] = forward
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnrichReverseDNS(t *testing.T) {
+	config = createDefaultConfig()
+	config.ReverseDNS.Enabled = true
+	config.ReverseDNS.ForwardHits = true
+	config.ReverseDNS.Resolver = "127.0.0.1:5353"
+
+	var lookups int32
+	rdns = newDNSResolver()
+	rdns.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		atomic.AddInt32(&lookups, 1)
+		if addr == "10.0.0.1" {
+			return []string{"host1.corp.example."}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	rdns.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		atomic.AddInt32(&lookups, 1)
+		return []string{"192.0.2.1"}, nil
+	}
+
+	obj := map[string]interface{}{
+		"src_ip":  "10.0.0.1",
+		"dest_ip": []string{"10.0.0.2", "10.0.0.1"},
+		"Hits":    []string{"Evil.example.com", "10.9.9.9"},
+	}
+	enrichReverseDNS(obj)
+
+	assert.Equal(t, []map[string]interface{}{
+		{"field": "src_ip", "ip": "10.0.0.1", "hostnames": []string{"host1.corp.example"}},
+		{"field": "dest_ip", "ip": "10.0.0.1", "hostnames": []string{"host1.corp.example"}},
+	}, obj["rdns"])
+	assert.Equal(t, []map[string]interface{}{
+		{"domain": "evil.example.com", "ips": []string{"192.0.2.1"}},
+	}, obj["dns"])
+	assert.EqualValues(t, 3, lookups)
+
+	//positive and negative answers are both cached
+	enrichReverseDNS(map[string]interface{}{"src_ip": "10.0.0.1", "dest_ip": "10.0.0.2"})
+	assert.EqualValues(t, 3, lookups)
+}
+
+func TestDNSResolverCache_evictsLeastRecentlyUsed(t *testing.T) {
+	config = createDefaultConfig()
+	config.ReverseDNS.CacheSize = 2
+	r := newDNSResolver()
+
+	r.setCached("a", []string{"a"}, 60)
+	r.setCached("b", []string{"b"}, 60)
+	_, ok := r.getCached("a")
+	assert.True(t, ok)
+
+	//full: only the least recently used entry goes
+	r.setCached("c", []string{"c"}, 60)
+	_, ok = r.getCached("b")
+	assert.False(t, ok)
+	_, ok = r.getCached("a")
+	assert.True(t, ok)
+	_, ok = r.getCached("c")
+	assert.True(t, ok)
+	assert.Len(t, r.cache, 2)
+	assert.Equal(t, 2, r.recent.Len())
+}
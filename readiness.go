@@ -0,0 +1,221 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//readinessConfig - how to tell that a new file has been completely written
+type readinessConfig struct {
+	Strategy       string   `json:"Strategy"`
+	PollInterval   int      `json:"PollInterval"`
+	StablePolls    int      `json:"StablePolls"`
+	QuietPeriod    int      `json:"QuietPeriod"`
+	MarkerSuffixes []string `json:"MarkerSuffixes"`
+	KeepMarker     bool     `json:"KeepMarker"`
+	TempSuffixes   []string `json:"TempSuffixes"`
+	Timeout        int      `json:"Timeout"`
+}
+
+var (
+	//fileActivity - when each file being waited on was last written to, fed by the folder watcher
+	fileActivity     = make(map[string]time.Time)
+	fileActivityLock sync.Mutex
+
+	readinessSleep = time.Sleep
+)
+
+func validateReadiness(r *readinessConfig) {
+	if r == nil {
+		return
+	}
+	switch r.Strategy {
+	case "", "sleep", "stable", "quiet", "marker", "rename":
+	default:
+		log.WithField("Strategy", r.Strategy).Fatal("Invalid Readiness Strategy, must be sleep, stable, quiet, marker or rename")
+	}
+}
+
+//inherit - fills in the settings a profile left out from the top-level readiness settings
+func (r readinessConfig) inherit(base *readinessConfig) *readinessConfig {
+	if r.Strategy == "" {
+		r.Strategy = base.Strategy
+	}
+	if r.PollInterval == 0 {
+		r.PollInterval = base.PollInterval
+	}
+	if r.StablePolls == 0 {
+		r.StablePolls = base.StablePolls
+	}
+	if r.QuietPeriod == 0 {
+		r.QuietPeriod = base.QuietPeriod
+	}
+	if r.MarkerSuffixes == nil {
+		r.MarkerSuffixes = base.MarkerSuffixes
+	}
+	if r.TempSuffixes == nil {
+		r.TempSuffixes = base.TempSuffixes
+	}
+	if r.Timeout == 0 {
+		r.Timeout = base.Timeout
+	}
+	return &r
+}
+
+//noteFileActivity - records a write to a file, for the "quiet" strategy
+func noteFileActivity(fullpath string) {
+	fileActivityLock.Lock()
+	fileActivity[fullpath] = time.Now()
+	fileActivityLock.Unlock()
+}
+
+func lastFileActivity(fullpath string) (time.Time, bool) {
+	fileActivityLock.Lock()
+	defer fileActivityLock.Unlock()
+	last, ok := fileActivity[fullpath]
+	return last, ok
+}
+
+func forgetFileActivity(fullpath string) {
+	fileActivityLock.Lock()
+	delete(fileActivity, fullpath)
+	fileActivityLock.Unlock()
+}
+
+func hasAnySuffix(fullpath string, suffixes []string) (string, bool) {
+	lower := strings.ToLower(fullpath)
+	for _, suffix := range suffixes {
+		if suffix != "" && strings.HasSuffix(lower, strings.ToLower(suffix)) {
+			return suffix, true
+		}
+	}
+	return "", false
+}
+
+//allReadinessConfigs - the top-level readiness settings and those of every profile
+func allReadinessConfigs() []*readinessConfig {
+	ans := []*readinessConfig{&config.Readiness}
+	for _, p := range config.Profiles {
+		if p.Readiness != nil {
+			ans = append(ans, p.Readiness.inherit(&config.Readiness))
+		}
+	}
+	return ans
+}
+
+//isReadinessArtifact - true for marker files and in-progress uploads, which are never processed themselves
+func isReadinessArtifact(fullpath string) bool {
+	for _, r := range allReadinessConfigs() {
+		var suffixes []string
+		switch r.Strategy {
+		case "marker":
+			suffixes = r.MarkerSuffixes
+		case "rename":
+			suffixes = r.TempSuffixes
+		default:
+			continue
+		}
+		if suffix, ok := hasAnySuffix(fullpath, suffixes); ok {
+			//only if the file it belongs to uses the strategy
+			base := fullpath[:len(fullpath)-len(suffix)]
+			if prof := selectProfile(base); prof.Readiness.Strategy == r.Strategy {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//findMarker - returns the marker file for fullpath, if one exists
+func findMarker(fullpath string, r *readinessConfig) (string, bool) {
+	for _, suffix := range r.MarkerSuffixes {
+		if _, err := os.Stat(fullpath + suffix); err == nil {
+			return fullpath + suffix, true
+		}
+	}
+	return "", false
+}
+
+//waitReady - blocks until the file is ready by the profile's strategy, returns false if the file went away
+func waitReady(fullpath string, r *readinessConfig) bool {
+	poll := time.Second * time.Duration(r.PollInterval)
+	if poll <= 0 {
+		poll = time.Second
+	}
+	var deadline time.Time
+	if r.Timeout > 0 {
+		deadline = time.Now().Add(time.Second * time.Duration(r.Timeout))
+	}
+	timedOut := func() bool {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			log.WithFields(log.Fields{"File": fullpath, "Strategy": r.Strategy}).Warn("Timed out waiting for file to be ready, processing it anyway")
+			return true
+		}
+		return false
+	}
+
+	switch r.Strategy {
+	case "stable":
+		var last fileStamp
+		stable := 0
+		for stable < r.StablePolls && !timedOut() {
+			changed, err := last.hasChanged(fullpath)
+			if err != nil {
+				return false
+			}
+			if changed {
+				last.update(fullpath)
+				stable = 0
+			} else {
+				stable++
+			}
+			if stable < r.StablePolls {
+				readinessSleep(poll)
+			}
+		}
+
+	case "quiet":
+		quiet := time.Second * time.Duration(r.QuietPeriod)
+		for !timedOut() {
+			last, ok := lastFileActivity(fullpath)
+			if info, err := os.Stat(fullpath); err != nil {
+				return false
+			} else if !ok || info.ModTime().After(last) {
+				last = info.ModTime()
+			}
+			wait := quiet - time.Since(last)
+			if wait <= 0 {
+				break
+			}
+			readinessSleep(wait)
+		}
+
+	case "marker":
+		for !timedOut() {
+			if marker, ok := findMarker(fullpath, r); ok {
+				if !r.KeepMarker {
+					if err := os.Remove(marker); err != nil {
+						log.WithError(err).WithField("Marker", marker).Warn("Unable to remove marker file")
+					}
+				}
+				break
+			}
+			if _, err := os.Stat(fullpath); err != nil {
+				return false
+			}
+			readinessSleep(poll)
+		}
+
+	case "rename":
+		//the file only appears under its final name once it is complete
+
+	default:
+		log.WithField("Seconds", config.WaitInterval).Debug("Sleeping")
+		readinessSleep(time.Second * time.Duration(config.WaitInterval))
+	}
+	return true
+}
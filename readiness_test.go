@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReadinessArtifact(t *testing.T) {
+	config = createDefaultConfig()
+	config.Readiness.Strategy = "rename"
+	config.Profiles = []profileConfig{
+		{Name: "marked", FileGlob: "*.csv", Readiness: &readinessConfig{Strategy: "marker"}},
+	}
+
+	assert.True(t, isReadinessArtifact("/watch/a_1.csv.done"))
+	assert.True(t, isReadinessArtifact("/watch/a_1.csv.OK"))
+	assert.False(t, isReadinessArtifact("/watch/a_1.csv"))
+	assert.True(t, isReadinessArtifact("/watch/a_1.log.part"))
+	assert.False(t, isReadinessArtifact("/watch/a_1.log"))
+	//the csv profile does not use the rename strategy
+	assert.False(t, isReadinessArtifact("/watch/a_1.csv.tmp"))
+
+	prof := selectProfile("/watch/a_1.csv")
+	assert.Equal(t, "marker", prof.Readiness.Strategy)
+	assert.Equal(t, []string{".done", ".ok"}, prof.Readiness.MarkerSuffixes)
+	assert.Equal(t, 3600, prof.Readiness.Timeout)
+}
+
+func TestWaitReady(t *testing.T) {
+	folder, err := ioutil.TempDir("", "saucepan_ready_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(folder)
+	defer func() { readinessSleep = time.Sleep }()
+
+	config = createDefaultConfig()
+	fullpath := filepath.Join(folder, "a_1.csv")
+	assert.NoError(t, ioutil.WriteFile(fullpath, []byte("a\n"), 0644))
+
+	//stable: the file grows during the first two polls
+	sleeps := 0
+	readinessSleep = func(time.Duration) {
+		sleeps++
+		if sleeps <= 2 {
+			f, _ := os.OpenFile(fullpath, os.O_APPEND|os.O_WRONLY, 0644)
+			f.WriteString("more\n")
+			f.Close()
+		}
+	}
+	r := &readinessConfig{Strategy: "stable", PollInterval: 1, StablePolls: 3}
+	assert.True(t, waitReady(fullpath, r))
+	assert.Equal(t, 5, sleeps)
+
+	//marker: ready once the marker shows up, which is then removed
+	sleeps = 0
+	readinessSleep = func(time.Duration) {
+		sleeps++
+		ioutil.WriteFile(fullpath+".ok", nil, 0644)
+	}
+	r = config.Readiness.inherit(&readinessConfig{})
+	r.Strategy = "marker"
+	assert.True(t, waitReady(fullpath, r))
+	assert.Equal(t, 1, sleeps)
+	_, err = os.Stat(fullpath + ".ok")
+	assert.True(t, os.IsNotExist(err))
+
+	//quiet: waits until there have been no writes for QuietPeriod
+	old := time.Now().Add(-time.Minute)
+	assert.NoError(t, os.Chtimes(fullpath, old, old))
+	r = &readinessConfig{Strategy: "quiet", QuietPeriod: 5}
+	sleeps = 0
+	readinessSleep = func(time.Duration) { sleeps++ }
+	assert.True(t, waitReady(fullpath, r))
+	assert.Equal(t, 0, sleeps)
+
+	noteFileActivity(fullpath)
+	readinessSleep = func(time.Duration) {
+		sleeps++
+		fileActivityLock.Lock()
+		fileActivity[fullpath] = old
+		fileActivityLock.Unlock()
+	}
+	assert.True(t, waitReady(fullpath, r))
+	assert.Equal(t, 1, sleeps)
+	forgetFileActivity(fullpath)
+
+	//a file that goes away is never ready
+	os.Remove(fullpath)
+	assert.False(t, waitReady(fullpath, &readinessConfig{Strategy: "stable", StablePolls: 3}))
+}
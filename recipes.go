@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+)
+
+//recipeConfig - per-recipe settings for filtering and scoring hits
+type recipeConfig struct {
+	Enabled   bool     `json:"Enabled"`
+	Severity  int      `json:"Severity"`
+	AllowList []string `json:"AllowList"`
+}
+
+//UnmarshalJSON - recipes are enabled unless explicitly disabled
+func (r *recipeConfig) UnmarshalJSON(data []byte) error {
+	type plainRecipeConfig recipeConfig
+	plain := plainRecipeConfig{Enabled: true}
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	*r = recipeConfig(plain)
+	return nil
+}
+
+//getRecipeConfig - the settings for a recipe, a recipe without a Severity gets the DefaultSeverity
+func getRecipeConfig(name string) recipeConfig {
+	if rc, ok := config.Recipes[name]; ok {
+		if rc.Severity == 0 {
+			rc.Severity = config.DefaultSeverity
+		}
+		return rc
+	}
+	return recipeConfig{Enabled: true, Severity: config.DefaultSeverity}
+}
+
+//isAllowed - checks a hit against an allow-list, entries can be a CIDR, a domain suffix ("*.example.com" or ".example.com") or an exact value
+func isAllowed(allowList []string, hit string) bool {
+	hitIP := net.ParseIP(hit)
+	lowerHit := strings.ToLower(hit)
+
+	for _, entry := range allowList {
+		if strings.Contains(entry, "/") {
+			if _, cidr, err := net.ParseCIDR(entry); err == nil {
+				if hitIP != nil && cidr.Contains(hitIP) {
+					return true
+				}
+				continue
+			}
+		}
+
+		lowerEntry := strings.ToLower(entry)
+		if strings.HasPrefix(lowerEntry, "*.") || strings.HasPrefix(lowerEntry, ".") {
+			base := strings.TrimPrefix(strings.TrimPrefix(lowerEntry, "*"), ".")
+			if lowerHit == base || strings.HasSuffix(lowerHit, "."+base) {
+				return true
+			}
+		} else if lowerHit == lowerEntry {
+			return true
+		}
+	}
+	return false
+}
+
+//filterHits - removes empty and allow-listed hits
+func filterHits(rc recipeConfig, hits []string) []string {
+	ans := make([]string, 0)
+	for _, hit := range hits {
+		if hit == "" || isAllowed(rc.AllowList, hit) {
+			continue
+		}
+		ans = append(ans, hit)
+	}
+	return ans
+}
+
+//addSauce - appends the CyberSaucier results to obj, returns true if any hits remain after filtering (i.e. it is "juice")
+func addSauce(obj map[string]interface{}, cybers []map[string]interface{}) bool {
+	cs := make([]interface{}, 0)
+	hitlist := make([]string, 0)
+	recipeNameList := make([]string, 0)
+	columnList := make([]string, 0)
+	maxSeverity := 0
+
+	for _, item := range cybers {
+		rslt, _ := item["result"].(string)
+		if len(rslt) == 0 { //looking for non-empty "result" fields
+			continue
+		}
+
+		if fieldname, ok := item["fieldname"]; ok {
+			obj[fieldname.(string)] = strings.Split(rslt, "\n")
+			continue
+		}
+
+		recipeName, _ := item["recipeName"].(string)
+		rc := getRecipeConfig(recipeName)
+		if !rc.Enabled {
+			continue
+		}
+
+		hits := filterHits(rc, strings.Split(rslt, "\n"))
+		if len(hits) == 0 {
+			continue
+		}
+
+		filtered := make(map[string]interface{}, len(item)+1)
+		for k, v := range item {
+			filtered[k] = v
+		}
+		filtered["result"] = strings.Join(hits, "\n")
+		filtered["severity"] = rc.Severity
+
+		cs = append(cs, filtered)
+		hitlist = append(hitlist, hits...)
+		recipeNameList = append(recipeNameList, recipeName)
+		if column, ok := item["column"].(string); ok && column != "" {
+			columnList = append(columnList, column)
+		}
+		if rc.Severity > maxSeverity {
+			maxSeverity = rc.Severity
+		}
+	}
+
+	if len(hitlist) == 0 {
+		return false
+	}
+
+	obj["Hits"] = hitlist
+	obj["RecipeNames"] = recipeNameList
+	obj["CyberSaucier"] = cs
+	if len(columnList) > 0 {
+		obj["HitColumns"] = uniqueStrings(columnList)
+	}
+	obj["Severity"] = maxSeverity
+	return true
+}
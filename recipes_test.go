@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecipeConfigDefaultsEnabled(t *testing.T) {
+	recipes := make(map[string]recipeConfig)
+	err := json.Unmarshal([]byte(`{"A": {"Severity": 3}, "B": {"Enabled": false}}`), &recipes)
+	assert.NoError(t, err)
+	assert.True(t, recipes["A"].Enabled)
+	assert.Equal(t, 3, recipes["A"].Severity)
+	assert.False(t, recipes["B"].Enabled)
+}
+
+func TestGetRecipeConfig_defaultSeverity(t *testing.T) {
+	config = createDefaultConfig()
+	config.DefaultSeverity = 4
+	assert.NoError(t, json.Unmarshal([]byte(`{"A": {"AllowList": ["x"]}, "B": {"Severity": 7}}`), &config.Recipes))
+
+	assert.Equal(t, 4, getRecipeConfig("A").Severity)
+	assert.True(t, getRecipeConfig("A").Enabled)
+	assert.Equal(t, 7, getRecipeConfig("B").Severity)
+	assert.Equal(t, 4, getRecipeConfig("Unlisted").Severity)
+}
+
+func TestIsAllowed(t *testing.T) {
+	allow := []string{"10.0.0.0/8", "*.corp.example.com", "Known-Good.org"}
+
+	assert.True(t, isAllowed(allow, "10.20.30.40"))
+	assert.False(t, isAllowed(allow, "11.20.30.40"))
+	assert.True(t, isAllowed(allow, "mail.corp.example.com"))
+	assert.True(t, isAllowed(allow, "corp.example.com"))
+	assert.False(t, isAllowed(allow, "notcorp.example.com"))
+	assert.True(t, isAllowed(allow, "known-good.org"))
+	assert.False(t, isAllowed(allow, "known-good.org.evil.com"))
+}
+
+func TestAddSauce(t *testing.T) {
+	config = createDefaultConfig()
+	config.Recipes["IPs"] = recipeConfig{Enabled: true, Severity: 5, AllowList: []string{"192.168.0.0/16"}}
+	config.Recipes["Noisy"] = recipeConfig{Enabled: false, Severity: 9}
+
+	obj := make(map[string]interface{})
+	cybers := []map[string]interface{}{
+		{"recipeName": "IPs", "result": "192.168.1.1\n8.8.8.8"},
+		{"recipeName": "Noisy", "result": "everything"},
+		{"recipeName": "Other", "result": "abc"},
+		{"recipeName": "Empty", "result": ""},
+	}
+
+	assert.True(t, addSauce(obj, cybers))
+	assert.Equal(t, []string{"8.8.8.8", "abc"}, obj["Hits"])
+	assert.Equal(t, []string{"IPs", "Other"}, obj["RecipeNames"])
+	assert.Equal(t, 5, obj["Severity"])
+
+	obj = make(map[string]interface{})
+	cybers = []map[string]interface{}{
+		{"recipeName": "IPs", "result": "192.168.1.1"},
+		{"recipeName": "Noisy", "result": "everything"},
+	}
+	assert.False(t, addSauce(obj, cybers))
+	assert.NotContains(t, obj, "Hits")
+}
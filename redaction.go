@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//redactionRule - a field (by name or regex) and/or a value pattern, and what to do with what matches
+type redactionRule struct {
+	Field      string `json:"Field"`
+	FieldRegex string `json:"FieldRegex"`
+	Pattern    string `json:"Pattern"`
+	Action     string `json:"Action"`
+	Mask       string `json:"Mask"`
+	IPv4Bits   int    `json:"IPv4Bits"`
+	IPv6Bits   int    `json:"IPv6Bits"`
+}
+
+type redactionConfig struct {
+	Enabled bool            `json:"Enabled"`
+	HMACKey string          `json:"HMACKey"`
+	Rules   []redactionRule `json:"Rules"`
+}
+
+const defaultRedactionMask = "********"
+
+var ipTokenRegex = regexp.MustCompile(`(?:\d{1,3}\.){3}\d{1,3}|[0-9A-Fa-f]*:[0-9A-Fa-f:.]*[0-9A-Fa-f]`)
+
+func validateRedaction(cfg redactionConfig) {
+	for _, rule := range cfg.Rules {
+		fields := log.Fields{"Field": rule.Field, "FieldRegex": rule.FieldRegex, "Pattern": rule.Pattern}
+		if rule.Field == "" && rule.FieldRegex == "" && rule.Pattern == "" {
+			log.WithFields(fields).Fatal("Redaction rule needs a Field, FieldRegex or Pattern")
+		}
+		for _, pattern := range []string{rule.FieldRegex, rule.Pattern} {
+			if pattern != "" {
+				if _, err := getRegex(pattern); err != nil {
+					log.WithError(err).WithFields(fields).Fatal("Invalid redaction regex")
+				}
+			}
+		}
+		switch rule.Action {
+		case "mask", "drop", "truncate":
+		case "hmac":
+			if cfg.Enabled && cfg.HMACKey == "" {
+				log.WithFields(fields).Fatal("Redaction HMACKey is required for hmac rules")
+			}
+		default:
+			log.WithFields(fields).WithField("Action", rule.Action).Fatal("Invalid redaction action, must be mask, drop, hmac or truncate")
+		}
+	}
+}
+
+//matchesField - true if the rule applies to the field at this (dotted) path; rules with only a Pattern apply to every field
+func (rule *redactionRule) matchesField(path string) bool {
+	if rule.Field == "" && rule.FieldRegex == "" {
+		return true
+	}
+	if rule.Field != "" && rule.Field == path {
+		return true
+	}
+	if rule.FieldRegex != "" {
+		if re, err := getRegex(rule.FieldRegex); err == nil && re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+//truncateIP - zeroes the host bits of an IP address, leaving anything that isn't one alone
+func (rule *redactionRule) truncateIP(value string) string {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return value
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		bits := rule.IPv4Bits
+		if bits <= 0 || bits > 32 {
+			bits = 24
+		}
+		return ip4.Mask(net.CIDRMask(bits, 32)).String()
+	}
+	bits := rule.IPv6Bits
+	if bits <= 0 || bits > 128 {
+		bits = 48
+	}
+	return ip.Mask(net.CIDRMask(bits, 128)).String()
+}
+
+//apply - runs the action on a whole value (or the part a Pattern matched)
+func (rule *redactionRule) apply(value string) string {
+	switch rule.Action {
+	case "mask":
+		if rule.Mask != "" {
+			return rule.Mask
+		}
+		return defaultRedactionMask
+	case "drop":
+		return ""
+	case "hmac":
+		mac := hmac.New(sha256.New, []byte(config.Redaction.HMACKey))
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	case "truncate":
+		if net.ParseIP(value) != nil {
+			return rule.truncateIP(value)
+		}
+		return ipTokenRegex.ReplaceAllStringFunc(value, rule.truncateIP)
+	}
+	return value
+}
+
+//redactions - the values field-scoped rules replaced in a record, so copies of them made elsewhere
+//(Hits, CyberSaucier results, intel matches, enrichment IPs) can be replaced too
+type redactions map[string]string
+
+//fieldScoped - true if the rule only applies to some fields, so copies of those values in other fields would escape it
+func (rule *redactionRule) fieldScoped() bool {
+	return rule.Field != "" || rule.FieldRegex != ""
+}
+
+//applyTracked - apply, remembering what a field-scoped rule replaced; truncate remembers each IP it changed
+func (rule *redactionRule) applyTracked(value string, seen redactions) string {
+	if seen == nil || !rule.fieldScoped() {
+		return rule.apply(value)
+	}
+	if rule.Action == "truncate" && net.ParseIP(value) == nil {
+		return ipTokenRegex.ReplaceAllStringFunc(value, func(token string) string {
+			return seen.add(token, rule.truncateIP(token))
+		})
+	}
+	return seen.add(value, rule.apply(value))
+}
+
+func (seen redactions) add(original string, replacement string) string {
+	if original != "" && original != replacement {
+		seen[original] = replacement
+	}
+	return replacement
+}
+
+//minRedactedCopy - shorter values are only replaced where they are a whole value, not inside other text
+const minRedactedCopy = 4
+
+//replaceCopies - replaces the remembered values wherever they are left in the record
+func (seen redactions) replaceCopies(obj map[string]interface{}) {
+	if len(seen) == 0 {
+		return
+	}
+	originals := make([]string, 0, len(seen))
+	for original := range seen {
+		if len(original) >= minRedactedCopy {
+			originals = append(originals, original)
+		}
+	}
+	//longest first, so a value is replaced before any shorter one inside it
+	sort.Slice(originals, func(i, j int) bool { return len(originals[i]) > len(originals[j]) })
+	pairs := make([]string, 0, len(originals)*2)
+	for _, original := range originals {
+		pairs = append(pairs, original, seen[original])
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	replace := func(value string) string {
+		if replacement, ok := seen[value]; ok {
+			return replacement
+		}
+		return replacer.Replace(value)
+	}
+	var walk func(value interface{}) interface{}
+	walk = func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case string:
+			return replace(v)
+		case []string:
+			for i := range v {
+				v[i] = replace(v[i])
+			}
+		case []interface{}:
+			for i := range v {
+				v[i] = walk(v[i])
+			}
+		case []map[string]interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]interface{}:
+			for key, item := range v {
+				v[key] = walk(item)
+			}
+		}
+		return value
+	}
+	walk(obj)
+}
+
+//redactString - applies every matching rule to a single value, keep is false if the field should be removed
+func redactString(path string, value string, seen redactions) (string, bool) {
+	for i := range config.Redaction.Rules {
+		rule := &config.Redaction.Rules[i]
+		if !rule.matchesField(path) {
+			continue
+		}
+		if rule.Pattern == "" {
+			if rule.Action == "drop" {
+				if seen != nil && rule.fieldScoped() {
+					seen.add(value, "")
+				}
+				return "", false
+			}
+			value = rule.applyTracked(value, seen)
+			continue
+		}
+		re, err := getRegex(rule.Pattern)
+		if err != nil {
+			continue
+		}
+		value = re.ReplaceAllStringFunc(value, func(match string) string {
+			return rule.applyTracked(match, seen)
+		})
+	}
+	return value, true
+}
+
+//redactValue - walks a field value, arrays keep the path of their field and maps add ".key" to it
+func redactValue(path string, value interface{}, seen redactions) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		return redactString(path, v, seen)
+	case []string:
+		ans := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, keep := redactString(path, item, seen); keep {
+				ans = append(ans, s)
+			}
+		}
+		return ans, len(ans) > 0 || len(v) == 0
+	case []interface{}:
+		ans := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if r, keep := redactValue(path, item, seen); keep {
+				ans = append(ans, r)
+			}
+		}
+		return ans, len(ans) > 0 || len(v) == 0
+	case []map[string]interface{}:
+		for _, item := range v {
+			redactMap(path+".", item, seen)
+		}
+		return v, true
+	case map[string]interface{}:
+		redactMap(path+".", v, seen)
+		return v, true
+	}
+
+	//numbers, bools, etc. are only affected by whole-field drops
+	for i := range config.Redaction.Rules {
+		rule := &config.Redaction.Rules[i]
+		if rule.Pattern == "" && rule.Action == "drop" && rule.matchesField(path) {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func redactMap(prefix string, obj map[string]interface{}, seen redactions) {
+	for key, value := range obj {
+		if redacted, keep := redactValue(prefix+key, value, seen); keep {
+			obj[key] = redacted
+		} else {
+			delete(obj, key)
+		}
+	}
+}
+
+//redactRecord - applies the redaction rules to a record, in place, before it is written anywhere;
+//values a field-scoped rule replaced are then replaced in every other field they were copied to
+func redactRecord(obj map[string]interface{}) {
+	if !config.Redaction.Enabled || len(config.Redaction.Rules) == 0 {
+		return
+	}
+	seen := make(redactions)
+	redactMap("", obj, seen)
+	seen.replaceCopies(obj)
+}
+
+//redactRow - applies the redaction rules to a raw CSV row, using the header (or column number) as the field name
+func redactRow(headers []string, record []string) []string {
+	if !config.Redaction.Enabled || len(config.Redaction.Rules) == 0 {
+		return record
+	}
+	ans := make([]string, len(record))
+	for i, value := range record {
+		ans[i], _ = redactString(columnLabel(i, headers), value, nil)
+	}
+	return ans
+}
+
+//redactText - applies the Pattern-only rules to free text, such as a parse error line
+func redactText(value string) string {
+	if !config.Redaction.Enabled || value == "" {
+		return value
+	}
+	for i := range config.Redaction.Rules {
+		rule := &config.Redaction.Rules[i]
+		if rule.Pattern == "" || rule.Field != "" || rule.FieldRegex != "" {
+			continue
+		}
+		if re, err := getRegex(rule.Pattern); err == nil {
+			value = re.ReplaceAllStringFunc(value, rule.apply)
+		}
+	}
+	return value
+}
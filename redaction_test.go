@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/csv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactRecord(t *testing.T) {
+	config = createDefaultConfig()
+	config.Redaction = redactionConfig{
+		Enabled: true,
+		HMACKey: "secret",
+		Rules: []redactionRule{
+			{Field: "user", Action: "hmac"},
+			{Field: "cookie", Action: "drop"},
+			{FieldRegex: `^(src_ip|geo\.ip)$`, Action: "truncate"},
+			{Field: "password", Action: "mask"},
+			{Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, Action: "mask", Mask: "<email>"},
+		},
+	}
+
+	obj := map[string]interface{}{
+		"user":     "alice",
+		"cookie":   "session=abc",
+		"src_ip":   []string{"10.1.2.3", "2001:db8:1:2::1"},
+		"password": "hunter2",
+		"message":  "mail from bob@example.com failed",
+		"geo":      []map[string]interface{}{{"ip": "8.8.4.4", "country": "US"}},
+		"Line":     3,
+	}
+	redactRecord(obj)
+
+	assert.Len(t, obj["user"], 64)
+	assert.NotEqual(t, "alice", obj["user"])
+	assert.NotContains(t, obj, "cookie")
+	assert.Equal(t, []string{"10.1.2.0", "2001:db8:1::"}, obj["src_ip"])
+	assert.Equal(t, "********", obj["password"])
+	assert.Equal(t, "mail from <email> failed", obj["message"])
+	assert.Equal(t, "8.8.4.0", obj["geo"].([]map[string]interface{})[0]["ip"])
+	assert.Equal(t, 3, obj["Line"])
+
+	//hmac is keyed and stable
+	other := map[string]interface{}{"user": "alice"}
+	redactRecord(other)
+	assert.Equal(t, obj["user"], other["user"])
+	config.Redaction.HMACKey = "another"
+	other = map[string]interface{}{"user": "alice"}
+	redactRecord(other)
+	assert.NotEqual(t, obj["user"], other["user"])
+}
+
+func TestRedactRow(t *testing.T) {
+	config = createDefaultConfig()
+	row := []string{"alice", "10.9.8.7:443", "bob@example.com"}
+	assert.Equal(t, row, redactRow([]string{"user"}, row))
+
+	config.Redaction = redactionConfig{
+		Enabled: true,
+		Rules: []redactionRule{
+			{Field: "user", Action: "drop"},
+			{Field: "1", Action: "truncate", IPv4Bits: 16},
+			{Pattern: `@[A-Za-z0-9.-]+`, Action: "drop"},
+		},
+	}
+	assert.Equal(t, []string{"", "10.9.0.0:443", "bob"}, redactRow([]string{"user"}, row))
+	assert.Equal(t, "user bob said hi", redactText("user bob@example.com said hi"))
+}
+
+func TestRedactRecord_copies(t *testing.T) {
+	config = createDefaultConfig()
+	config.Redaction = redactionConfig{
+		Enabled: true,
+		Rules: []redactionRule{
+			{Field: "user", Action: "mask"},
+			{Field: "client", Action: "truncate"},
+			{Field: "token", Action: "drop"},
+		},
+	}
+
+	obj := map[string]interface{}{
+		"user":         "alice.smith",
+		"client":       "10.1.2.3:443",
+		"token":        "abcd1234",
+		"Hits":         []string{"alice.smith", "10.1.2.3", "abcd1234"},
+		"CyberSaucier": []interface{}{map[string]interface{}{"result": "login alice.smith from 10.1.2.3"}},
+		"intel":        []map[string]interface{}{{"field": "user", "value": "alice.smith"}},
+		"geo":          []map[string]interface{}{{"field": "client", "ip": "10.1.2.3"}},
+		"status":       "ok",
+	}
+	redactRecord(obj)
+
+	assert.Equal(t, "********", obj["user"])
+	assert.Equal(t, "10.1.2.0:443", obj["client"])
+	assert.NotContains(t, obj, "token")
+	assert.Equal(t, []string{"********", "10.1.2.0", ""}, obj["Hits"])
+	assert.Equal(t, "login ******** from 10.1.2.0", obj["CyberSaucier"].([]interface{})[0].(map[string]interface{})["result"])
+	assert.Equal(t, "********", obj["intel"].([]map[string]interface{})[0]["value"])
+	assert.Equal(t, "10.1.2.0", obj["geo"].([]map[string]interface{})[0]["ip"])
+	assert.Equal(t, "ok", obj["status"])
+}
+
+func TestProcessRecord_parseErrorRedacted(t *testing.T) {
+	config = createDefaultConfig()
+	config.CyberSaucier.Enabled = false
+	config.ElasticSearch.Enabled = false
+	config.Redaction = redactionConfig{
+		Enabled: true,
+		Rules:   []redactionRule{{Field: "user", Action: "mask"}},
+	}
+
+	run := newFileRun("/tmp/test.csv", config.defaultProfile(), []string{"user", "msg"}, ',')
+	run.processRecord(2, []string{"alice", "bad \"quote"}, &csv.ParseError{Line: 2, Column: 5, Err: csv.ErrBareQuote})
+
+	if assert.Len(t, run.parseerrors, 1) {
+		assert.Equal(t, "********,bad \"quote", run.parseerrors[0].Raw)
+	}
+}
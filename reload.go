@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+//fileStamp - the modification time and size of a file when it was last loaded
+type fileStamp struct {
+	ModTime time.Time
+	Size    int64
+}
+
+//hasChanged - checks if the file is different from the last time it was loaded
+func (s *fileStamp) hasChanged(fullpath string) (bool, error) {
+	info, err := os.Stat(fullpath)
+	if err != nil {
+		return false, err
+	}
+	return !info.ModTime().Equal(s.ModTime) || info.Size() != s.Size, nil
+}
+
+//update - records the current state of the file
+func (s *fileStamp) update(fullpath string) {
+	if info, err := os.Stat(fullpath); err == nil {
+		s.ModTime = info.ModTime()
+		s.Size = info.Size()
+	}
+}
+
+//startReloader - calls reload every interval seconds, for picking up files that change on disk
+func startReloader(interval int, reload func()) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second * time.Duration(interval))
+		for range ticker.C {
+			reload()
+		}
+	}()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type retryConfig struct {
+	MaxAttempts      int    `json:"MaxAttempts"`
+	Backoff          int    `json:"Backoff"`
+	MaxBackoff       int    `json:"MaxBackoff"`
+	QuarantineFolder string `json:"QuarantineFolder"`
+}
+
+//failureSuffix - the sidecar next to a failed file, explaining why it failed
+const failureSuffix = ".failed.json"
+
+//fileFailure - the sidecar written next to a file in the FailedFolder (or QuarantineFolder)
+type fileFailure struct {
+	File         string    `json:"File"`
+	Source       string    `json:"Source"`
+	Profile      string    `json:"Profile"`
+	Attempts     int       `json:"Attempts"`
+	Reasons      []string  `json:"Reasons"`
+	FirstFailure time.Time `json:"FirstFailure"`
+	LastFailure  time.Time `json:"LastFailure"`
+	NextRetry    time.Time `json:"NextRetry,omitempty"`
+	Quarantined  bool      `json:"Quarantined"`
+}
+
+var (
+	retryTimers     = make(map[string]*time.Timer)
+	retryTimersLock sync.Mutex
+)
+
+//retryEnabled - true if files that fail go to the FailedFolder instead of the DoneFolder
+func retryEnabled() bool {
+	return config.FailedFolder != ""
+}
+
+func quarantineFolder() string {
+	if config.Retry.QuarantineFolder != "" {
+		return config.Retry.QuarantineFolder
+	}
+	return filepath.Join(config.FailedFolder, "Quarantine")
+}
+
+//isFailedFile - true if the file is waiting in the FailedFolder to be retried
+func isFailedFile(fullpath string) bool {
+	return retryEnabled() && samePath(filepath.Dir(fullpath), config.FailedFolder)
+}
+
+//retryDelay - the wait before the next attempt, doubling after every failure up to MaxBackoff
+func retryDelay(attempts int) time.Duration {
+	delay := time.Second * time.Duration(config.Retry.Backoff)
+	max := time.Second * time.Duration(config.Retry.MaxBackoff)
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if max > 0 && delay >= max {
+			break
+		}
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	return delay
+}
+
+//loadFailure - reads the sidecar of a file in the FailedFolder, nil if it has never failed
+func loadFailure(fullpath string) *fileFailure {
+	if !isFailedFile(fullpath) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(fullpath + failureSuffix)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("File", fullpath).Warn("Unable to read failure sidecar")
+		}
+		return nil
+	}
+	var failure fileFailure
+	if err := json.Unmarshal(data, &failure); err != nil {
+		log.WithError(err).WithField("File", fullpath).Warn("Unable to read failure sidecar")
+		return nil
+	}
+	return &failure
+}
+
+func saveFailure(fullpath string, failure *fileFailure) error {
+	data, err := json.MarshalIndent(failure, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fullpath+failureSuffix, data, 0644)
+}
+
+//moveFailedFile - moves a file that failed to the FailedFolder and schedules its retry,
+//or to the QuarantineFolder once it has used up MaxAttempts; returns where the file is now
+func moveFailedFile(fullpath string, run *fileRun, previous *fileFailure) string {
+	now := time.Now()
+	failure := previous
+	if failure == nil {
+		failure = &fileFailure{Source: fullpath, FirstFailure: now}
+	}
+	failure.File = run.filename
+	failure.Profile = run.prof.Name
+	failure.Attempts++
+	failure.Reasons = run.failures
+	failure.LastFailure = now
+	failure.NextRetry = time.Time{}
+
+	folder := config.FailedFolder
+	if config.Retry.MaxAttempts > 0 && failure.Attempts >= config.Retry.MaxAttempts {
+		folder = quarantineFolder()
+		failure.Quarantined = true
+	} else {
+		failure.NextRetry = now.Add(retryDelay(failure.Attempts))
+	}
+
+	fields := log.Fields{"File": fullpath, "Attempts": failure.Attempts, "Reasons": strings.Join(failure.Reasons, "; ")}
+	dst, err := moveFile(fullpath, filepath.Join(folder, run.filename))
+	if err != nil {
+		//the file is still where it was, the next run picks it up again
+		log.WithError(err).WithFields(fields).Error("Unable to move failed file")
+		return fullpath
+	}
+	if err := saveFailure(dst, failure); err != nil {
+		log.WithError(err).WithFields(fields).Warn("Unable to write failure sidecar")
+	}
+	if previous != nil && dst != fullpath {
+		os.Remove(fullpath + failureSuffix)
+	}
+
+	if failure.Quarantined {
+		log.WithFields(fields).WithField("dst", dst).Error("File quarantined, giving up on it")
+	} else {
+		log.WithFields(fields).WithFields(log.Fields{"dst": dst, "NextRetry": failure.NextRetry}).Warn("File failed, will retry")
+		scheduleRetry(dst, failure.NextRetry)
+	}
+	return dst
+}
+
+//clearFailure - removes the sidecar of a retried file that has now been processed
+func clearFailure(fullpath string) {
+	if err := os.Remove(fullpath + failureSuffix); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).WithField("File", fullpath).Warn("Unable to remove failure sidecar")
+	}
+}
+
+//scheduleRetry - queues the file again at the given time
+func scheduleRetry(fullpath string, at time.Time) {
+	retryTimersLock.Lock()
+	defer retryTimersLock.Unlock()
+	if t, ok := retryTimers[fullpath]; ok {
+		t.Stop()
+	}
+	retryTimers[fullpath] = time.AfterFunc(time.Until(at), func() {
+		retryTimersLock.Lock()
+		delete(retryTimers, fullpath)
+		retryTimersLock.Unlock()
+		log.WithField("File", fullpath).Info("Retrying failed file")
+		queueFile(fullpath)
+	})
+}
+
+//initRetry - schedules the retries of files left in the FailedFolder by a previous run
+func initRetry() {
+	if !retryEnabled() {
+		return
+	}
+	infos, err := ioutil.ReadDir(config.FailedFolder)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("Folder", config.FailedFolder).Warn("Unable to read FailedFolder")
+		}
+		return
+	}
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), failureSuffix) {
+			continue
+		}
+		fullpath := filepath.Join(config.FailedFolder, strings.TrimSuffix(info.Name(), failureSuffix))
+		if _, err := os.Stat(fullpath); err != nil {
+			continue
+		}
+		if failure := loadFailure(fullpath); failure != nil && !failure.Quarantined {
+			scheduleRetry(fullpath, failure.NextRetry)
+		}
+	}
+}
+
+//stopRetries - cancels every scheduled retry, the files stay in the FailedFolder for the next run
+func stopRetries() {
+	retryTimersLock.Lock()
+	defer retryTimersLock.Unlock()
+	for fullpath, t := range retryTimers {
+		t.Stop()
+		delete(retryTimers, fullpath)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	config = createDefaultConfig()
+	config.Retry.Backoff = 60
+	config.Retry.MaxBackoff = 300
+
+	assert.Equal(t, time.Minute, retryDelay(1))
+	assert.Equal(t, 2*time.Minute, retryDelay(2))
+	assert.Equal(t, 4*time.Minute, retryDelay(3))
+	assert.Equal(t, 5*time.Minute, retryDelay(4))
+	assert.Equal(t, 5*time.Minute, retryDelay(40))
+}
+
+func readFailure(t *testing.T, fullpath string) fileFailure {
+	var failure fileFailure
+	data, err := ioutil.ReadFile(fullpath + failureSuffix)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &failure))
+	return failure
+}
+
+func TestFileHandler_retry(t *testing.T) {
+	watch, err := ioutil.TempDir("", "saucepan_input_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(watch)
+	done, err := ioutil.TempDir("", "saucepan_output_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(done)
+	defer stopRetries()
+
+	broken := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken {
+			w.Write([]byte("not json"))
+		} else {
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer server.Close()
+
+	config = createDefaultConfig()
+	config.WatchFolder = watch
+	config.DoneFolder = done
+	config.FailedFolder = filepath.Join(watch, "Failed")
+	config.Retry.MaxAttempts = 2
+	config.Retry.Backoff = 3600
+	config.CyberSaucier.Enabled = true
+	config.CyberSaucier.URL = server.URL
+	config.CyberSaucier.Query = ""
+
+	csvFile := filepath.Join(watch, "a_1.csv")
+	failedFile := filepath.Join(config.FailedFolder, "a_1.csv")
+	assert.NoError(t, ioutil.WriteFile(csvFile, []byte("1\n2\n"), 0644))
+	assert.True(t, isOutputFolder(config.FailedFolder))
+
+	//the first failure goes to the FailedFolder, with a retry scheduled
+	fileHandler(csvFile)
+	assert.FileExists(t, failedFile)
+	failure := readFailure(t, failedFile)
+	assert.Equal(t, 1, failure.Attempts)
+	assert.Equal(t, csvFile, failure.Source)
+	assert.False(t, failure.Quarantined)
+	assert.True(t, failure.NextRetry.After(time.Now()))
+	if assert.Len(t, failure.Reasons, 1) {
+		assert.True(t, strings.HasPrefix(failure.Reasons[0], "CyberSaucier: "))
+	}
+	assert.Len(t, retryTimers, 1)
+
+	//out of attempts, it is quarantined
+	fileHandler(failedFile)
+	quarantined := filepath.Join(config.FailedFolder, "Quarantine", "a_1.csv")
+	assert.FileExists(t, quarantined)
+	assert.NoFileExists(t, failedFile+failureSuffix)
+	failure = readFailure(t, quarantined)
+	assert.Equal(t, 2, failure.Attempts)
+	assert.True(t, failure.Quarantined)
+
+	//a retry that works goes to the DoneFolder and loses its sidecar
+	broken = false
+	assert.NoError(t, os.Rename(quarantined, failedFile))
+	failure.Attempts = 1
+	failure.Quarantined = false
+	assert.NoError(t, saveFailure(failedFile, &failure))
+	fileHandler(failedFile)
+	assert.FileExists(t, filepath.Join(done, "a_1.csv"))
+	assert.NoFileExists(t, failedFile)
+	assert.NoFileExists(t, failedFile+failureSuffix)
+}
+
+func TestMoveFailedFile_moveFails(t *testing.T) {
+	watch, err := ioutil.TempDir("", "saucepan_input_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(watch)
+	defer stopRetries()
+
+	config = createDefaultConfig()
+	config.WatchFolder = watch
+	//a file where the folder should be, so nothing can be moved into it
+	blocker := filepath.Join(watch, "blocker")
+	assert.NoError(t, ioutil.WriteFile(blocker, nil, 0644))
+	config.FailedFolder = filepath.Join(blocker, "Failed")
+
+	csvFile := filepath.Join(watch, "a_1.csv")
+	assert.NoError(t, ioutil.WriteFile(csvFile, []byte("1\n"), 0644))
+	run := newFileRun(csvFile, config.defaultProfile(), nil, ',')
+	run.fail("ElasticSearch", errors.New("down"))
+
+	assert.Equal(t, csvFile, moveFailedFile(csvFile, run, nil))
+	assert.FileExists(t, csvFile)
+}
+
+func TestFileHandler_retryHoldsNoSauce(t *testing.T) {
+	watch, err := ioutil.TempDir("", "saucepan_input_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(watch)
+	done, err := ioutil.TempDir("", "saucepan_output_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(done)
+	defer stopRetries()
+
+	//"1" never has any hits, "2" fails until the server is fixed
+	broken := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if broken && strings.Contains(string(body), "2") {
+			w.Write([]byte("not json"))
+		} else {
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer server.Close()
+
+	config = createDefaultConfig()
+	config.WatchFolder = watch
+	config.DoneFolder = done
+	config.FailedFolder = filepath.Join(watch, "Failed")
+	config.Retry.Backoff = 3600
+	config.SaveNoSauce = true
+	config.CyberSaucier.Enabled = true
+	config.CyberSaucier.URL = server.URL
+	config.CyberSaucier.Query = ""
+
+	csvFile := filepath.Join(watch, "a_1.csv")
+	failedFile := filepath.Join(config.FailedFolder, "a_1.csv")
+	noSauceFile := filepath.Join(done, config.GetMacrod("NoSauceFile"))
+	assert.NoError(t, ioutil.WriteFile(csvFile, []byte("1\n2\n"), 0644))
+
+	//the failed run's NoSauce rows are read again by the retry, so aren't written yet
+	fileHandler(csvFile)
+	assert.FileExists(t, failedFile)
+	assert.NoFileExists(t, noSauceFile)
+
+	//the retry that works writes them, once
+	broken = false
+	fileHandler(failedFile)
+	assert.FileExists(t, filepath.Join(done, "a_1.csv"))
+	data, err := ioutil.ReadFile(noSauceFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n2\n", string(data))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//ruleConfig - a record-level rule, evaluated after a line is parsed and before any enrichment
+type ruleConfig struct {
+	Name   string `json:"Name"`
+	Expr   string `json:"Expr"`
+	Action string `json:"Action"`
+}
+
+//ruleResult - what the rules decided for a record
+type ruleResult struct {
+	Drop      bool
+	SkipSauce bool
+	Index     bool
+	Matched   []string
+}
+
+//ruleCounter - per-rule match counts for a file
+type ruleCounter map[string]int
+
+func validateRules(rules []ruleConfig) {
+	for _, rule := range rules {
+		if _, err := getExpr(rule.Expr); err != nil {
+			log.WithError(err).WithField("Rule", rule.Name).Fatal("Invalid rule expression")
+		}
+		switch rule.Action {
+		case "drop", "skip", "index":
+		default:
+			log.WithFields(log.Fields{"Rule": rule.Name, "Action": rule.Action}).Fatal("Invalid rule action, must be drop, skip or index")
+		}
+	}
+}
+
+//evaluateRules - runs every rule against the record, a matching "drop" rule stops evaluation
+func evaluateRules(obj map[string]interface{}, counts ruleCounter) ruleResult {
+	result := ruleResult{Matched: make([]string, 0)}
+	for _, rule := range config.Rules {
+		node, err := getExpr(rule.Expr)
+		if err != nil {
+			log.WithError(err).WithField("Rule", rule.Name).Warn("Invalid rule expression")
+			continue
+		}
+		if !exprTruthy(node.eval(obj)) {
+			continue
+		}
+
+		result.Matched = append(result.Matched, rule.Name)
+		if counts != nil {
+			counts[rule.Name]++
+		}
+		switch rule.Action {
+		case "drop":
+			result.Drop = true
+			return result
+		case "skip":
+			result.SkipSauce = true
+		case "index":
+			result.Index = true
+		}
+	}
+	return result
+}
+
+//logRuleCounts - writes the per-rule match counts for a file to the log
+func logRuleCounts(fullpath string, counts ruleCounter) {
+	for _, rule := range config.Rules {
+		if n := counts[rule.Name]; n > 0 {
+			log.WithFields(log.Fields{
+				"File":    fullpath,
+				"Rule":    rule.Name,
+				"Action":  rule.Action,
+				"Matched": n,
+			}).Info("Rule matches")
+		}
+	}
+}
+
+//testRules - parses a CSV file with its profile and prints what the rules (or a single expression) do to each record
+func testRules(out io.Writer, fullpath string, expression string) error {
+	rules := config.Rules
+	if expression != "" {
+		if _, err := getExpr(expression); err != nil {
+			return err
+		}
+		rules = []ruleConfig{{Name: "expression", Expr: expression, Action: "index"}}
+	}
+	config.Rules = rules
+
+	f, err := os.Open(fullpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	prof := selectProfile(fullpath)
+	reader := newCSVReader(prof, f)
+	headers, line := readHeaders(prof, reader)
+	filename, tag, dtStamp := parseFileName(fullpath)
+
+	counts := make(ruleCounter)
+	total := 0
+	dropped := 0
+	for {
+		record, err := reader.Read()
+		line++
+		if err == io.EOF {
+			break
+		}
+		if err != nil && record == nil {
+			fmt.Fprintf(out, "line %d: %s\n", line, err)
+			continue
+		}
+		total++
+
+		obj, _ := parseLine(prof, filename, line, tag, dtStamp, headers, record)
+		result := evaluateRules(obj, counts)
+		if result.Drop {
+			dropped++
+		}
+		if len(result.Matched) > 0 {
+			action := "keep"
+			switch {
+			case result.Drop:
+				action = "drop"
+			case result.Index && result.SkipSauce:
+				action = "index, skip CyberSaucier"
+			case result.Index:
+				action = "index"
+			case result.SkipSauce:
+				action = "skip CyberSaucier"
+			}
+			redactRecord(obj)
+			objJSON, _ := json.Marshal(obj)
+			fmt.Fprintf(out, "line %d: %v => %s\n    %s\n", line, result.Matched, action, objJSON)
+		}
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(out, "\n%d records, %d dropped\n", total, dropped)
+	for _, name := range names {
+		fmt.Fprintf(out, "%s: %d\n", name, counts[name])
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateRules(t *testing.T) {
+	config = createDefaultConfig()
+	config.Rules = []ruleConfig{
+		{Name: "noisy", Expr: `status == 200 && method == "OPTIONS"`, Action: "drop"},
+		{Name: "internal", Expr: `src_ip =~ "^10\."`, Action: "skip"},
+		{Name: "errors", Expr: `status >= 500`, Action: "index"},
+	}
+	counts := make(ruleCounter)
+
+	result := evaluateRules(map[string]interface{}{"status": "200", "method": "OPTIONS", "src_ip": "10.0.0.1"}, counts)
+	assert.True(t, result.Drop)
+	assert.Equal(t, []string{"noisy"}, result.Matched)
+
+	result = evaluateRules(map[string]interface{}{"status": "503", "method": "GET", "src_ip": "10.0.0.1"}, counts)
+	assert.False(t, result.Drop)
+	assert.True(t, result.SkipSauce)
+	assert.True(t, result.Index)
+
+	result = evaluateRules(map[string]interface{}{"status": "200", "method": "GET", "src_ip": "8.8.8.8"}, counts)
+	assert.Empty(t, result.Matched)
+
+	assert.Equal(t, ruleCounter{"noisy": 1, "internal": 1, "errors": 1}, counts)
+}
+
+func TestTestRules(t *testing.T) {
+	folder, err := ioutil.TempDir("", "saucepan_rules_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	csvFile := filepath.Join(folder, "proxy_2019-01-01T000000.csv")
+	assert.NoError(t, ioutil.WriteFile(csvFile, []byte("status,url\n200,http://a\n404,http://b\n500,http://c\n"), 0644))
+
+	config = createDefaultConfig()
+	config.CSVOptions.FirstRowHeader = true
+
+	var out bytes.Buffer
+	assert.NoError(t, testRules(&out, csvFile, `Tag == "proxy" && status >= 400`))
+	assert.Contains(t, out.String(), "line 3: [expression] => index")
+	assert.Contains(t, out.String(), "line 4: [expression] => index")
+	assert.NotContains(t, out.String(), "line 2:")
+	assert.Contains(t, out.String(), "3 records, 0 dropped\nexpression: 2\n")
+
+	assert.Error(t, testRules(&out, csvFile, `status >=`))
+}
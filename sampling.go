@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//samplingRule - which files a rule applies to and how much of their low-value records to keep
+type samplingRule struct {
+	Tag         string  `json:"Tag"`
+	FilePattern string  `json:"FilePattern"`
+	KeepOneIn   int     `json:"KeepOneIn"`
+	KeepPercent float64 `json:"KeepPercent"`
+}
+
+type samplingConfig struct {
+	Enabled bool           `json:"Enabled"`
+	Rules   []samplingRule `json:"Rules"`
+}
+
+//fileSampler - the sampling rule (if any) for one file
+type fileSampler struct {
+	rule *samplingRule
+}
+
+func validateSampling(cfg samplingConfig) {
+	for _, rule := range cfg.Rules {
+		fields := log.Fields{"Tag": rule.Tag, "FilePattern": rule.FilePattern}
+		if rule.FilePattern != "" {
+			if _, err := filepath.Match(rule.FilePattern, ""); err != nil {
+				log.WithError(err).WithFields(fields).Fatal("Invalid sampling FilePattern")
+			}
+		}
+		if rule.KeepOneIn < 0 || rule.KeepPercent < 0 || rule.KeepPercent > 100 {
+			log.WithFields(fields).Fatal("Invalid sampling rule, KeepOneIn must be positive and KeepPercent between 0 and 100")
+		}
+		if rule.KeepOneIn > 0 && rule.KeepPercent > 0 {
+			log.WithFields(fields).Fatal("Sampling rule can only have one of KeepOneIn or KeepPercent")
+		}
+	}
+}
+
+func (rule *samplingRule) matches(filename string, tag string) bool {
+	if rule.Tag != "" && !strings.EqualFold(rule.Tag, tag) {
+		return false
+	}
+	if rule.FilePattern != "" {
+		if ok, err := filepath.Match(rule.FilePattern, filename); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+//newFileSampler - picks the first sampling rule that matches the file's name and tag
+func newFileSampler(filename string, tag string) *fileSampler {
+	sampler := &fileSampler{}
+	if !config.Sampling.Enabled {
+		return sampler
+	}
+	for i := range config.Sampling.Rules {
+		if config.Sampling.Rules[i].matches(filename, tag) {
+			sampler.rule = &config.Sampling.Rules[i]
+			break
+		}
+	}
+	return sampler
+}
+
+//keep - decides if a low-value record is kept, by its line number or a hash of the row (never a running count),
+//so a resumed or retried file keeps the same records
+func (s *fileSampler) keep(line int, record []string) bool {
+	if s.rule == nil {
+		return true
+	}
+	if s.rule.KeepOneIn > 0 {
+		return line%s.rule.KeepOneIn == 0
+	}
+	if s.rule.KeepPercent > 0 {
+		sum := sha256.Sum256([]byte(strings.Join(record, "\x00")))
+		return float64(binary.BigEndian.Uint64(sum[:8])%10000) < s.rule.KeepPercent*100
+	}
+	return true
+}
+
+//rateLimiter - a token bucket that blocks callers, rather than dropping, once the rate is reached
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+//newRateLimiter - a limiter for perSecond records per second, nil (no limit) if perSecond is not positive
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:   float64(perSecond),
+		tokens: float64(perSecond),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+//wait - blocks until a record may be sent
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens < 0 {
+		//holding the lock while sleeping queues up the other senders behind this one
+		delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+		log.WithField("Delay", delay).Trace("Rate limited")
+		l.sleep(delay)
+		l.last = l.last.Add(delay)
+		l.tokens = 0
+	}
+}
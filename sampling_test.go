@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSampler(t *testing.T) {
+	config = createDefaultConfig()
+	config.Sampling = samplingConfig{
+		Enabled: true,
+		Rules: []samplingRule{
+			{Tag: "firewall", KeepOneIn: 10},
+			{FilePattern: "proxy_*.csv", KeepPercent: 25},
+		},
+	}
+
+	sampler := newFileSampler("firewall_20190101.csv", "Firewall")
+	kept := 0
+	for i := 1; i <= 100; i++ {
+		if sampler.keep(i, []string{strconv.Itoa(i)}) {
+			kept++
+		}
+	}
+	assert.Equal(t, 10, kept)
+
+	//a resumed file (or a new sampler for a retry) keeps the same lines
+	resumed := newFileSampler("firewall_20190101.csv", "Firewall")
+	for i := 55; i <= 100; i++ {
+		assert.Equal(t, sampler.keep(i, []string{strconv.Itoa(i)}), resumed.keep(i, []string{strconv.Itoa(i)}))
+	}
+
+	sampler = newFileSampler("proxy_20190101.csv", "proxy")
+	kept = 0
+	for i := 0; i < 4000; i++ {
+		record := []string{"row", strconv.Itoa(i)}
+		keep := sampler.keep(i, record)
+		assert.Equal(t, keep, sampler.keep(i+1, record))
+		if keep {
+			kept++
+		}
+	}
+	assert.InDelta(t, 1000, kept, 150)
+
+	sampler = newFileSampler("dns_20190101.csv", "dns")
+	assert.Nil(t, sampler.rule)
+	assert.True(t, sampler.keep(1, []string{"x"}))
+
+	config.Sampling.Enabled = false
+	assert.Nil(t, newFileSampler("firewall_20190101.csv", "firewall").rule)
+}
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0))
+
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	slept := time.Duration(0)
+	limiter := newRateLimiter(10)
+	limiter.last = now
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	//the first second's worth goes straight through, then each record waits its turn
+	for i := 0; i < 10; i++ {
+		limiter.wait()
+	}
+	assert.Equal(t, time.Duration(0), slept)
+	for i := 0; i < 10; i++ {
+		limiter.wait()
+	}
+	assert.Equal(t, time.Second, slept)
+}
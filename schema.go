@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//fieldSchema - type, name and default for a single field of a record
+type fieldSchema struct {
+	Name      string `json:"Name"`
+	Type      string `json:"Type"`
+	Rename    string `json:"Rename"`
+	Drop      bool   `json:"Drop"`
+	Default   string `json:"Default"`
+	Separator string `json:"Separator"`
+	Format    string `json:"Format"`
+}
+
+//coercionError - recorded in the "_errors" field of a record when a value does not match its schema type
+type coercionError struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+//defaultSchema - a value with more than one address or port becomes an array, a single one stays a string
+func defaultSchema() []fieldSchema {
+	return []fieldSchema{
+		{Name: "dest_ip", Type: "split", Separator: " "},
+		{Name: "dest_port", Type: "split", Separator: " "},
+		{Name: "src_ip", Type: "split", Separator: " "},
+	}
+}
+
+func validateSchema(schema []fieldSchema) {
+	for _, fs := range schema {
+		switch fs.Type {
+		case "", "string", "int", "float", "bool", "ip", "timestamp", "string-array", "split":
+		default:
+			log.WithFields(log.Fields{"Name": fs.Name, "Type": fs.Type}).Fatal("Invalid Schema Type, must be string, int, float, bool, ip, timestamp, string-array or split")
+		}
+	}
+}
+
+func parseTimestamp(value string, format string) (time.Time, error) {
+	switch format {
+	case "":
+		return time.Parse(time.RFC3339, value)
+	case "unix", "unixms":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if format == "unixms" {
+			return time.Unix(0, n*int64(time.Millisecond)).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	default:
+		return time.Parse(format, value)
+	}
+}
+
+//coerceValue - converts a single string to the schema type
+func coerceValue(fs fieldSchema, value string) (interface{}, error) {
+	trimmed := strings.TrimSpace(value)
+	switch fs.Type {
+	case "", "string":
+		return value, nil
+	case "int":
+		return strconv.ParseInt(trimmed, 10, 64)
+	case "float":
+		return strconv.ParseFloat(trimmed, 64)
+	case "bool":
+		return strconv.ParseBool(trimmed)
+	case "ip":
+		ip := net.ParseIP(trimmed)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address")
+		}
+		return ip.String(), nil
+	case "timestamp":
+		return parseTimestamp(trimmed, fs.Format)
+	case "split":
+		sep := fs.Separator
+		if sep == "" {
+			sep = ","
+		}
+		if !strings.Contains(value, sep) {
+			return value, nil
+		}
+		return strings.Split(value, sep), nil
+	case "string-array":
+		sep := fs.Separator
+		if sep == "" {
+			sep = ","
+		}
+		ans := make([]string, 0)
+		for _, part := range strings.Split(value, sep) {
+			if part = strings.TrimSpace(part); part != "" {
+				ans = append(ans, part)
+			}
+		}
+		return ans, nil
+	}
+	return nil, fmt.Errorf("unknown schema type %q", fs.Type)
+}
+
+//applySchema - coerces, renames, drops and defaults the fields of obj
+func applySchema(prof *profileConfig, obj map[string]interface{}) {
+	errors := make([]coercionError, 0)
+
+	for _, fs := range prof.Schema {
+		value, ok := obj[fs.Name]
+		if fs.Drop {
+			delete(obj, fs.Name)
+			continue
+		}
+
+		if s, isString := value.(string); (!ok || (isString && s == "")) && fs.Default != "" {
+			value, ok = fs.Default, true
+		}
+		if !ok {
+			continue
+		}
+
+		//a value that doesn't convert is left out (ES would reject the whole record for it), "_errors" has it
+		switch v := value.(type) {
+		case string:
+			coerced, err := coerceValue(fs, v)
+			if err != nil {
+				errors = append(errors, coercionError{Field: fs.Name, Value: v, Type: fs.Type, Error: err.Error()})
+				delete(obj, fs.Name)
+				continue
+			}
+			value = coerced
+		case []string:
+			if fs.Type != "string-array" && fs.Type != "split" {
+				values := make([]interface{}, 0, len(v))
+				for _, item := range v {
+					coerced, err := coerceValue(fs, item)
+					if err != nil {
+						errors = append(errors, coercionError{Field: fs.Name, Value: item, Type: fs.Type, Error: err.Error()})
+						continue
+					}
+					values = append(values, coerced)
+				}
+				if len(values) == 0 {
+					delete(obj, fs.Name)
+					continue
+				}
+				value = values
+			}
+		}
+
+		if fs.Rename != "" && fs.Rename != fs.Name {
+			delete(obj, fs.Name)
+			obj[fs.Rename] = value
+		} else {
+			obj[fs.Name] = value
+		}
+	}
+
+	if len(errors) > 0 {
+		obj["_errors"] = errors
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySchema(t *testing.T) {
+	config = createDefaultConfig()
+	config.Schema = append(config.Schema,
+		fieldSchema{Name: "status", Type: "int"},
+		fieldSchema{Name: "bytes", Type: "float", Rename: "size"},
+		fieldSchema{Name: "blocked", Type: "bool", Default: "false"},
+		fieldSchema{Name: "client", Type: "ip"},
+		fieldSchema{Name: "ts", Type: "timestamp", Format: "2006-01-02 15:04:05"},
+		fieldSchema{Name: "epoch", Type: "timestamp", Format: "unix"},
+		fieldSchema{Name: "session", Drop: true},
+		fieldSchema{Name: "ports", Type: "int"},
+	)
+
+	obj := map[string]interface{}{
+		"dest_ip":   "1.1.1.1 2.2.2.2",
+		"src_ip":    "3.3.3.3",
+		"status":    "40x",
+		"bytes":     "12.5",
+		"blocked":   "",
+		"client":    "::ffff:10.0.0.1",
+		"ts":        "2019-06-01 12:30:00",
+		"epoch":     "0",
+		"session":   "secret",
+		"untouched": "x",
+		"ports":     []string{"80", "http"},
+	}
+	applySchema(config.defaultProfile(), obj)
+
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, obj["dest_ip"])
+	assert.Equal(t, "3.3.3.3", obj["src_ip"])
+	assert.NotContains(t, obj, "status")
+	assert.Equal(t, 12.5, obj["size"])
+	assert.NotContains(t, obj, "bytes")
+	assert.Equal(t, false, obj["blocked"])
+	assert.Equal(t, "10.0.0.1", obj["client"])
+	assert.Equal(t, time.Date(2019, 6, 1, 12, 30, 0, 0, time.UTC), obj["ts"])
+	assert.Equal(t, time.Unix(0, 0).UTC(), obj["epoch"])
+	assert.NotContains(t, obj, "session")
+	assert.Equal(t, "x", obj["untouched"])
+	assert.Equal(t, []interface{}{int64(80)}, obj["ports"])
+	assert.Equal(t, []coercionError{
+		{Field: "status", Value: "40x", Type: "int", Error: `strconv.ParseInt: parsing "40x": invalid syntax`},
+		{Field: "ports", Value: "http", Type: "int", Error: `strconv.ParseInt: parsing "http": invalid syntax`},
+	}, obj["_errors"])
+}
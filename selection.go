@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//fileMatcher - one include or exclude rule, every field that is set has to match
+type fileMatcher struct {
+	Glob    string `json:"Glob"`
+	Regex   string `json:"Regex"`
+	MinSize int64  `json:"MinSize"`
+	MaxSize int64  `json:"MaxSize"`
+}
+
+type selectionConfig struct {
+	Include []fileMatcher `json:"Include"`
+	Exclude []fileMatcher `json:"Exclude"`
+}
+
+func validateSelection(cfg selectionConfig) {
+	for _, list := range [][]fileMatcher{cfg.Include, cfg.Exclude} {
+		for _, m := range list {
+			fields := log.Fields{"Glob": m.Glob, "Regex": m.Regex}
+			if m.Glob != "" {
+				if _, err := filepath.Match(m.Glob, ""); err != nil {
+					log.WithError(err).WithFields(fields).Fatal("Invalid selection Glob")
+				}
+			}
+			if m.Regex != "" {
+				if _, err := getRegex(m.Regex); err != nil {
+					log.WithError(err).WithFields(fields).Fatal("Invalid selection Regex")
+				}
+			}
+			if m.MinSize < 0 || m.MaxSize < 0 || (m.MaxSize > 0 && m.MinSize > m.MaxSize) {
+				log.WithFields(fields).Fatal("Invalid selection size bounds")
+			}
+		}
+	}
+}
+
+func (m *fileMatcher) hasSize() bool {
+	return m.MinSize > 0 || m.MaxSize > 0
+}
+
+//matches - checks the file against the rule; a Glob with a "/" is matched against the path below the
+//WatchFolder, otherwise against the file name. A negative size is not known yet and passes the size bounds
+func (m *fileMatcher) matches(fullpath string, size int64) bool {
+	if m.Glob != "" {
+		target := filepath.Base(fullpath)
+		if strings.Contains(m.Glob, "/") {
+			rel, err := filepath.Rel(config.WatchFolder, fullpath)
+			if err != nil {
+				return false
+			}
+			target = filepath.ToSlash(rel)
+		}
+		if ok, err := filepath.Match(m.Glob, target); err != nil || !ok {
+			return false
+		}
+	}
+
+	if m.Regex != "" {
+		re, err := getRegex(m.Regex)
+		if err != nil || !re.MatchString(filepath.ToSlash(fullpath)) {
+			return false
+		}
+	}
+
+	if size >= 0 {
+		if m.MinSize > 0 && size < m.MinSize {
+			return false
+		}
+		if m.MaxSize > 0 && size > m.MaxSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *fileMatcher) String() string {
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+//selectFile - decides whether a file is processed, and why: the IgnoreList, then the first Exclude that matches,
+//then (when there are any) the first Include that matches. With a negative size (a file that is still being
+//written) the size bounds are left for later, so only rules that can already rule the file out do
+func selectFile(fullpath string, size int64) (bool, string) {
+	if tst, ignored := ignoreMatch(fullpath); ignored {
+		return false, fmt.Sprintf("IgnoreList %q", tst)
+	}
+
+	for i, m := range config.Selection.Exclude {
+		if size < 0 && m.hasSize() {
+			continue
+		}
+		if m.matches(fullpath, size) {
+			return false, fmt.Sprintf("Exclude[%d] %s", i, m.String())
+		}
+	}
+
+	if len(config.Selection.Include) == 0 {
+		return true, "no Include rules"
+	}
+	for i, m := range config.Selection.Include {
+		if m.matches(fullpath, size) {
+			return true, fmt.Sprintf("Include[%d] %s", i, m.String())
+		}
+	}
+	return false, "no Include matched"
+}
+
+//isSelected - true if the file should be processed, logging the reason when it isn't
+func isSelected(fullpath string, size int64) bool {
+	ok, reason := selectFile(fullpath, size)
+	if !ok {
+		log.WithFields(log.Fields{"File": fullpath, "Reason": reason}).Info("Ignoring file")
+	}
+	return ok
+}
+
+//explainFile - prints whether the file would be processed and which rule decided it, for the -explain flag
+func explainFile(out io.Writer, fullpath string) {
+	size := int64(-1)
+	sizeText := "unknown (file not found, size bounds not checked)"
+	if info, err := os.Stat(fullpath); err == nil {
+		size = info.Size()
+		sizeText = fmt.Sprintf("%d", size)
+	}
+	ok, reason := selectFile(fullpath, size)
+
+	fmt.Fprintf(out, "File:     %s\n", fullpath)
+	fmt.Fprintf(out, "Size:     %s\n", sizeText)
+	fmt.Fprintf(out, "Selected: %t\n", ok)
+	fmt.Fprintf(out, "Rule:     %s\n", reason)
+	if ok {
+		prof := selectProfile(fullpath)
+		name := prof.Name
+		if name == "" {
+			name = "(default)"
+		}
+		fmt.Fprintf(out, "Profile:  %s\n", name)
+		if isReadinessArtifact(fullpath) {
+			fmt.Fprintf(out, "Note:     skipped as a readiness marker or partial file\n")
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectFile(t *testing.T) {
+	config = createDefaultConfig()
+	config.WatchFolder = filepath.Join("watch")
+	config.IgnoreList = []string{"ignore"}
+	config.Selection.Include = []fileMatcher{
+		{Glob: "*.csv"},
+		{Glob: "firewall/*.log"},
+	}
+	config.Selection.Exclude = []fileMatcher{
+		{Regex: `/nojuice_[^/]*$`},
+		{Glob: "*.csv", MaxSize: 10},
+	}
+
+	tests := []struct {
+		path     string
+		size     int64
+		selected bool
+		reason   string
+	}{
+		{"watch/a.csv", 100, true, "Include[0]"},
+		{"watch/firewall/a.log", 100, true, "Include[1]"},
+		{"watch/other/a.log", 100, false, "no Include matched"},
+		{"watch/a.csv.tmp", 100, false, "no Include matched"},
+		{"watch/ignore/a.csv", 100, false, "IgnoreList"},
+		{"watch/nojuice_2020.csv", 100, false, "Exclude[0]"},
+		{"watch/small.csv", 5, false, "Exclude[1]"},
+		//the size isn't known yet, so the size rule is left for later
+		{"watch/small.csv", -1, true, "Include[0]"},
+	}
+	for _, tst := range tests {
+		ok, reason := selectFile(filepath.FromSlash(tst.path), tst.size)
+		assert.Equal(t, tst.selected, ok, tst.path)
+		assert.True(t, strings.HasPrefix(reason, tst.reason), "%s: %s", tst.path, reason)
+	}
+}
+
+func TestSelectFile_noRules(t *testing.T) {
+	config = createDefaultConfig()
+	ok, reason := selectFile("anything.txt", 0)
+	assert.True(t, ok)
+	assert.Equal(t, "no Include rules", reason)
+}
+
+func TestExplainFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "saucepan_input_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fullpath := filepath.Join(dir, "a.csv")
+	assert.NoError(t, ioutil.WriteFile(fullpath, []byte("1\n"), 0644))
+
+	config = createDefaultConfig()
+	config.WatchFolder = dir
+	config.Selection.Exclude = []fileMatcher{{Glob: "*.csv", MaxSize: 10}}
+
+	var out bytes.Buffer
+	explainFile(&out, fullpath)
+	assert.Contains(t, out.String(), "Size:     2\n")
+	assert.Contains(t, out.String(), "Selected: false\n")
+	assert.Contains(t, out.String(), `Rule:     Exclude[0] {"Glob":"*.csv","Regex":"","MinSize":0,"MaxSize":10}`)
+
+	out.Reset()
+	explainFile(&out, filepath.Join(dir, "missing.csv"))
+	assert.Contains(t, out.String(), "Selected: true\n")
+	assert.Contains(t, out.String(), "Profile:  (default)\n")
+}
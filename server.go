@@ -12,22 +12,29 @@ import (
 	"net/http"
 
 	"os"
-	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	oqueue "github.com/otium/queue"
 
-	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	configFile string
-	loglevel   string
-	config     *configuration
-	fileQueue  *oqueue.Queue
+	configFile    string
+	loglevel      string
+	testRulesFile string
+	testRuleExpr  string
+	explainPath   string
+	config        *configuration
+	fileQueue     *oqueue.Queue
+
+	regexCache     = make(map[string]*regexp.Regexp)
+	regexCacheLock sync.Mutex
 )
 
 //SauceParseError - an error that occurs upon parsing a CSV line
@@ -42,9 +49,12 @@ type SauceParseError struct {
 func init() {
 	flag.StringVar(&configFile, "config", "config.json", "Configuration file To use")
 	flag.StringVar(&loglevel, "loglevel", "warn", "Level of debugging {debug|info|warn|error|panic}")
+	flag.StringVar(&testRulesFile, "testrules", "", "CSV file to run through the rules (prints the result and exits)")
+	flag.StringVar(&testRuleExpr, "rule", "", "Rule expression to try with -testrules instead of the configured rules")
+	flag.StringVar(&explainPath, "explain", "", "File path to check against the IgnoreList and Selection rules (prints which rule matched and exits)")
 }
 
-func sendToCyberS(input string) ([]map[string]interface{}, error) {
+func sendToCyberS(query string, input string) ([]map[string]interface{}, error) {
 	//proxyURL, _ := url.Parse("http://localhost:8888")
 	client := &http.Client{
 		Timeout: time.Second * 10,
@@ -56,7 +66,7 @@ func sendToCyberS(input string) ([]map[string]interface{}, error) {
 			//Proxy:               http.ProxyURL(proxyURL),
 		}),
 	}
-	req, reqErr := http.NewRequest("POST", config.CyberSaucier.URL+config.CyberSaucier.Query, strings.NewReader(input))
+	req, reqErr := http.NewRequest("POST", config.CyberSaucier.URL+query, strings.NewReader(input))
 	if reqErr != nil {
 		return nil, reqErr
 	}
@@ -94,86 +104,291 @@ func sendToCyberS(input string) ([]map[string]interface{}, error) {
 	return ans, nil
 }
 
-func parseExtra(obj *map[string]interface{}, records []string, checkvalue string) {
+//getSauce - runs the input through CyberSaucier and/or the native recipes, depending on configuration
+func getSauce(prof *profileConfig, input string) ([]map[string]interface{}, error) {
+	var ans []map[string]interface{}
+	var err error
 
-	for _, extra := range config.ExtraParsing {
-		start := strings.Index(checkvalue, extra.Start)
-		if start > -1 {
-			trueStart := start + len(extra.Start)
-			end := strings.Index(checkvalue[trueStart:], extra.End)
+	if config.CyberSaucier.Enabled {
+		ans, err = sendToCyberS(*prof.CyberSaucierQuery, input)
+	}
+
+	if config.NativeRecipes.Enabled {
+		if config.NativeRecipes.Mode == "alongside" || !config.CyberSaucier.Enabled || err != nil {
+			ans = append(ans, runNativeRecipes(input)...)
+		}
+		//the fallback stood in for CyberSaucier, so the record was sauced after all
+		if err != nil && config.NativeRecipes.Mode != "alongside" {
+			log.WithError(err).Warn("Error in CyberSaucier, used the native recipes")
+			err = nil
+		}
+	}
+
+	return ans, err
+}
+
+func parseExtra(prof *profileConfig, obj *map[string]interface{}, headers []string, records []string, checkvalue string) {
+
+	for _, extra := range prof.ExtraParsing {
+		value := checkvalue
+		if extra.Column != "" {
+			idx := resolveColumn(extra.Column, headers)
+			if idx < 0 || idx >= len(records) {
+				continue
+			}
+			value = records[idx]
+		}
+
+		if extra.Regex != "" {
+			parseExtraRegex(obj, extra, value)
+			continue
+		}
+
+		matches := make([]string, 0)
+		offset := 0
+		for offset <= len(value) {
+			start := strings.Index(value[offset:], extra.Start)
+			if start <= -1 {
+				break
+			}
+			trueStart := offset + start + len(extra.Start)
+			end := strings.Index(value[trueStart:], extra.End)
 			if end <= -1 {
-				end = len(checkvalue)
+				end = len(value)
 			} else {
 				end += trueStart
 			}
+			matches = append(matches, value[trueStart:end])
+
+			if extra.Match != "all" || end == offset {
+				break
+			}
+			//past the End delimiter, otherwise with Start == End it would start the next match
+			offset = end + len(extra.End)
+		}
+
+		if len(matches) > 0 {
+			if extra.Match == "all" {
+				(*obj)[extra.Name] = matches
+			} else {
+				(*obj)[extra.Name] = matches[0]
+			}
+		}
+	}
+
+}
+
+//parseExtraRegex - named groups become fields (nested under Name if it is set), otherwise the first group (or the whole match) is used
+func parseExtraRegex(obj *map[string]interface{}, extra extraparsing, value string) {
+	re, err := getRegex(extra.Regex)
+	if err != nil {
+		log.WithError(err).WithField("Name", extra.Name).Warn("Invalid ExtraParsing regex")
+		return
+	}
+
+	limit := 1
+	if extra.Match == "all" {
+		limit = -1
+	}
+	found := re.FindAllStringSubmatch(value, limit)
+	if len(found) == 0 {
+		return
+	}
+
+	groups := re.SubexpNames()
+	named := false
+	for _, g := range groups {
+		if g != "" {
+			named = true
+			break
+		}
+	}
+
+	if !named {
+		matches := make([]string, len(found))
+		for i, m := range found {
+			if len(m) > 1 {
+				matches[i] = m[1]
+			} else {
+				matches[i] = m[0]
+			}
+		}
+		if extra.Match == "all" {
+			(*obj)[extra.Name] = matches
+		} else {
+			(*obj)[extra.Name] = matches[0]
+		}
+		return
+	}
 
-			(*obj)[extra.Name] = checkvalue[trueStart:end]
+	matches := make([]map[string]interface{}, len(found))
+	for i, m := range found {
+		matches[i] = make(map[string]interface{})
+		for j, g := range groups {
+			if g != "" {
+				matches[i][g] = m[j]
+			}
 		}
 	}
 
+	switch {
+	case extra.Name != "" && extra.Match == "all":
+		(*obj)[extra.Name] = matches
+	case extra.Name != "":
+		(*obj)[extra.Name] = matches[0]
+	case extra.Match == "all":
+		for _, g := range groups {
+			if g != "" {
+				values := make([]string, len(matches))
+				for i, m := range matches {
+					values[i] = m[g].(string)
+				}
+				(*obj)[g] = values
+			}
+		}
+	default:
+		for g, v := range matches[0] {
+			(*obj)[g] = v
+		}
+	}
+}
+
+//getRegex - compiles (and caches) a regular expression, Go's regexp runs in linear time so analyst-written patterns are safe
+func getRegex(pattern string) (*regexp.Regexp, error) {
+	regexCacheLock.Lock()
+	defer regexCacheLock.Unlock()
+
+	if re, ok := regexCache[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCache[pattern] = re
+	return re, nil
 }
 
 func shouldIgnore(fullpath string) bool {
-	ans := false
+	_, ignored := ignoreMatch(fullpath)
+	return ignored
+}
+
+//ignoreMatch - the IgnoreList entry found in the path, if there is one
+func ignoreMatch(fullpath string) (string, bool) {
 	for _, tst := range config.IgnoreList {
 		if strings.Contains(fullpath, tst) {
-			ans = true
-			break
+			return tst, true
 		}
 	}
-	return ans
+	return "", false
 }
 
 func fileWalkHandler(fullpath string, info os.FileInfo, err error) error {
 	if err != nil {
 		log.Warn(err)
-	} else if !info.IsDir() {
+	} else if info.IsDir() {
+		if isOutputFolder(fullpath) {
+			return filepath.SkipDir
+		}
+	} else if isReadinessArtifact(fullpath) {
+		log.WithField("Fullpath", fullpath).Debug("Skipping marker or partial file")
+	} else if !isSelected(fullpath, -1) {
+		return nil
+	} else if selectProfile(fullpath).Readiness.Strategy == "marker" {
+		//files left from before a restart may still be waiting on their marker
+		go waitFile(fullpath)
+	} else {
 		queueFile(fullpath)
 	}
 	return nil
 }
 
-func parseLine(filename string, line int, tag string, dtStamp string, headers []string, record []string) (map[string]interface{}, string) {
+func parseLine(prof *profileConfig, filename string, line int, tag string, dtStamp string, headers []string, record []string) (map[string]interface{}, []captureValue) {
 
 	obj := make(map[string]interface{})
 	obj["FileName"] = filename
 	obj["Line"] = line
 	obj["Tag"] = tag
+	if prof.Name != "" {
+		obj["Profile"] = prof.Name
+	}
 	if dtStamp != "" {
 		obj["DateTime"] = dtStamp
 	}
 	numRecords := len(record)
-	var checkvalue string
 
 	if len(headers) == numRecords {
 		for i := 0; i < numRecords; i++ {
-			switch headers[i] {
-			case "dest_ip":
-				fallthrough
-			case "dest_port":
-				fallthrough
-			case "src_ip":
-				if strings.Contains(record[i], " ") {
-					obj[headers[i]] = strings.Split(record[i], " ")
-				} else {
-					obj[headers[i]] = record[i]
-				}
-			default:
-				obj[headers[i]] = record[i]
-			}
+			obj[headers[i]] = record[i]
 		}
 	}
 
-	if config.CSVOptions.CaptureColumn < numRecords {
-		checkvalue = record[config.CSVOptions.CaptureColumn]
-	} else {
-		checkvalue = strings.Join(record, ",")
+	captures := getCaptures(prof, headers, record)
+
+	//Extra parsing - in reverse, so the first capture column with a match wins
+	for i := len(captures) - 1; i >= 0; i-- {
+		parseExtra(prof, &obj, headers, record, captures[i].Value)
 	}
 
-	//Extra parsing
-	parseExtra(&obj, record, checkvalue)
+	//Structured sub-parsers
+	runSubParsers(prof, obj, headers, record, captures)
+
+	//Field types, renames, drops and defaults
+	applySchema(prof, obj)
+
+	return obj, captures
+}
+
+//enrichRecord - runs the local enrichment stages on a record before it is sent on
+func enrichRecord(obj map[string]interface{}) {
+	enrichGeoIP(obj)
+	enrichReverseDNS(obj)
+}
+
+//parseFileName - splits a "{tag}_{datetime}.csv" file name into its parts
+func parseFileName(fullpath string) (filename string, tag string, dtStamp string) {
+	filename = filepath.Base(fullpath)
+	i := strings.Index(filename, "_")
+	if i > -1 {
+		parts := strings.Split(filename, "_")
+		dtStamp = strings.Split(parts[1], ".")[0]
+		tag = parts[0]
+	}
+	return filename, tag, dtStamp
+}
+
+//newCSVReader - creates a CSV reader with the profile's options
+func newCSVReader(prof *profileConfig, r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = false
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	if prof.CSVOptions.Delimiter != "" {
+		reader.Comma, _ = utf8.DecodeRuneInString(prof.CSVOptions.Delimiter)
+	}
+	if prof.CSVOptions.Comment != "" {
+		reader.Comment, _ = utf8.DecodeRuneInString(prof.CSVOptions.Comment)
+	}
+	return reader
+}
 
-	return obj, checkvalue
+//readHeaders - reads the header row (if there is one), returns the headers and the number of lines read
+func readHeaders(prof *profileConfig, reader *csv.Reader) ([]string, int) {
+	headers := make([]string, 0)
+	line := 0
+	if prof.CSVOptions.FirstRowHeader {
+		var err error
+		headers, err = reader.Read()
+		line++
+		if err != nil {
+			log.WithError(err).Warn("Error reading first record")
+		}
+	}
+	if len(prof.CSVOptions.Headers) > 0 {
+		headers = prof.CSVOptions.Headers
+	}
+	return headers, line
 }
 
 func fileHandler(infileObj interface{}) {
@@ -187,23 +402,22 @@ func fileHandler(infileObj interface{}) {
 		}
 
 		if !info.IsDir() {
-			if shouldIgnore(fullpath) {
-				log.WithFields(log.Fields{"File": fullpath}).Info("Ignoring file")
+			if !isFailedFile(fullpath) && !isSelected(fullpath, info.Size()) {
+				return
 			} else if info.Size() == 0 {
 				log.WithFields(log.Fields{"File": fullpath}).Info("Empty file")
 				os.Remove(fullpath)
 			} else {
-				log.WithFields(log.Fields{"File": fullpath}).Info("Processing file")
-
-				filename := info.Name()
-				var dtStamp string
-				var tag string
-				i := strings.Index(filename, "_")
-				if i > -1 {
-					parts := strings.Split(filename, "_")
-					dtStamp = strings.Split(parts[1], ".")[0]
-					tag = parts[0]
+				prof := selectProfile(fullpath)
+
+				//A retried file keeps the profile it was first processed with, the FailedFolder may not match it
+				failure := loadFailure(fullpath)
+				if failure != nil && failure.Profile != "" {
+					if p := findProfile(failure.Profile); p != nil {
+						prof = p
+					}
 				}
+				log.WithFields(log.Fields{"File": fullpath, "Profile": prof.Name}).Info("Processing file")
 
 				f, err := os.Open(fullpath)
 				if err != nil {
@@ -211,178 +425,112 @@ func fileHandler(infileObj interface{}) {
 					return
 				}
 
-				reader := csv.NewReader(f)
-				reader.ReuseRecord = false
-				reader.LazyQuotes = true
-				reader.TrimLeadingSpace = true
-
-				headers := make([]string, 0)
-				nojuice := make([][]string, 0)
-				parseerrors := make([]SauceParseError, 0)
-
-				line := 0
-				if config.CSVOptions.FirstRowHeader {
-					headers, err = reader.Read()
-					line++
+				//Pick up where a previous run left off
+				var fstate *fileState
+				resumeLine := 0
+				alreadyDone := false
+				if state != nil {
+					fstate, err = state.begin(fullpath)
 					if err != nil {
-						log.WithError(err).Warn("Error reading first record")
+						log.WithError(err).WithField("File", fullpath).Warn("Unable to check processing state")
+						fstate = nil
+					} else if fstate.Status == stateDone {
+						log.WithField("File", fullpath).Info("File already processed")
+						alreadyDone = true
+					} else if fstate.Line > 0 {
+						log.WithFields(log.Fields{"File": fullpath, "Line": fstate.Line}).Info("Resuming file")
+						resumeLine = fstate.Line
 					}
 				}
 
-				for {
+				reader := newCSVReader(prof, f)
+				headers, line := readHeaders(prof, reader)
+				run := newFileRun(fullpath, prof, headers, reader.Comma)
+				forgetESFailures(fullpath)
+				defer forgetESFailures(fullpath)
+
+				stopped := false
+				for !alreadyDone {
+					if isAbandoningFiles() {
+						stopped = true
+						break
+					}
 					record, err := reader.Read()
 					line++
 
 					if err == io.EOF {
 						break
 					}
-
-					if err != nil {
-						if pe, ok := err.(*csv.ParseError); ok {
-							if pe.Err != csv.ErrFieldCount {
-								spe := SauceParseError{
-									File:       fullpath,
-									Line:       line,
-									Column:     pe.Column,
-									ErrMessage: pe.Err.Error(),
-									Raw:        "",
-								}
-								parseerrors = append(parseerrors, spe)
-							}
-						} else {
-							log.WithError(err).Warn("Could not read record")
-							continue
-						}
+					if line <= resumeLine {
+						continue
 					}
-
-					obj, checkvalue := parseLine(filename, line, tag, dtStamp, headers, record)
-
-					//Send to CyberSaucier
-					if config.CyberSaucier.Enabled {
-						cybers, err := sendToCyberS(checkvalue)
-						if err != nil {
-							log.WithError(err).Warn("Error in CyberSaucier")
-							//hadAnyErrors = true
-						}
-
-						//Append CyberSaucier results to obj
-						cResults := make([]map[string]interface{}, 0)
-						for _, result := range cybers {
-							if val, ok := result["result"]; ok && len(val.(string)) > 0 { //looking for non-empty "result" fields
-								cResults = append(cResults, result)
-							}
-						}
-
-						//Only push if there are results
-						if len(cResults) > 0 {
-							cs := make([]interface{}, 0)
-							hitlist := make([]string, 0)
-							recipeNameList := make([]string, 0)
-							for _, item := range cResults {
-								rslt := item["result"].(string)
-								if fieldname, ok := item["fieldname"]; ok {
-									obj[fieldname.(string)] = strings.Split(rslt, "\n")
-								} else {
-									cs = append(cs, item)
-									hitlist = append(hitlist, strings.Split(rslt, "\n")...)
-									recipeNameList = append(recipeNameList, item["recipeName"].(string))
-								}
-							}
-
-							obj["Hits"] = hitlist
-							obj["RecipeNames"] = recipeNameList
-							obj["CyberSaucier"] = cs
-
-							log.WithFields(log.Fields{"Record": record, "CyberSaucier": cybers, "Obj": obj}).Trace("Juice")
-							//Send to ES
-							err = sendDataToES(obj)
-							if err != nil {
-								log.WithError(err).Warn("Error in CyberSaucier")
-								//hadAnyErrors = true
-							}
-						} else {
-							log.WithFields(log.Fields{"Record": record, "CyberSaucier": cybers, "Obj": obj}).Trace("No Juice")
-							if config.SaveNoSauce {
-								nojuice = append(nojuice, record)
-							}
-						}
-					} else {
-						//CyberSaucier is disabled - push it all
-						//Send to ES
-						err = sendDataToES(obj)
-						if err != nil {
-							log.WithError(err).Warn("Error in CyberSaucier")
-							//hadAnyErrors = true
+					if fstate != nil && !run.failed() && config.State.CheckpointLines > 0 && line-1-fstate.Line >= config.State.CheckpointLines {
+						if state.checkpoint(fstate, line-1) {
+							run.settleDedup(false)
+							run.settleRows(false)
 						}
 					}
+					run.processRecord(line, record, err)
 				}
 
 				f.Close()
 
-				//Move the file
-				if config.MoveAfterProcessed {
-					newDst := path.Join(config.DoneFolder, filename)
-					log.WithFields(log.Fields{
-						"src": fullpath,
-						"dst": newDst,
-					}).Debug("Moving File")
-					err := os.Rename(fullpath, newDst)
-					if err != nil {
-						log.WithFields(log.Fields{
-							"src": fullpath,
-							"dst": newDst,
-							"err": err,
-						}).Warning("Error moving File")
-					}
+				//Shutting down: the file stays where it is, checkpointed (if it can be) for the next run to resume
+				if stopped {
+					//the lines after the checkpoint are read again next time, so must not be duplicates then
+					checkpointed := fstate != nil && !run.failed() && line > fstate.Line && state.checkpoint(fstate, line)
+					run.settleDedup(!checkpointed)
+					run.settleRows(!checkpointed)
+					log.WithFields(log.Fields{"File": fullpath, "Line": line}).Warn("Stopped processing file for shutdown")
+					return
 				}
 
-				//Save Parse errors
-				if len(parseerrors) > 0 {
-					parseerrorfile := config.GetMacrod("ParseErrorFile")
-					outFile := path.Join(config.DoneFolder, parseerrorfile)
-					oFile, err := os.OpenFile(outFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-					if err != nil {
-						log.WithError(err).Warn("Error opening ParseErrorFile")
-					} else {
-						defer oFile.Close()
-						for _, e := range parseerrors {
-							errorJSON, err := json.Marshal(e)
-							if err != nil {
-								log.WithError(err).Warn("Error marshalling parseerror to json")
-							} else {
-								_, err = io.WriteString(oFile, string(errorJSON)+"\n")
-								if err != nil {
-									log.WithError(err).Warn("Error writing to ParseErrorFile")
-								}
-							}
-						}
-					}
+				//Make sure the outputs have everything from the file, so it is known whether it all made it
+				if err := flushFile(fullpath); err != nil {
+					run.outputFailed = true
+					run.fail("ElasticSearch", err)
 				}
+				run.settleDedup(run.failed())
 
-				//Save "NoSauce" results
-				if config.SaveNoSauce && len(nojuice) > 0 {
-					nosaucefile := config.GetMacrod("NoSauceFile")
+				//Checkpoint the file as done, once the outputs have it
+				if fstate != nil && !alreadyDone {
+					state.finish(fstate, line-1, run.failed())
+				}
 
-					outFile := path.Join(config.DoneFolder, nosaucefile)
-					oFile, err := os.OpenFile(outFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-					if err != nil {
-						log.WithError(err).Warn("Error opening NoSauceFile")
-					} else {
-						defer oFile.Close()
-						writer := csv.NewWriter(oFile)
-						err := writer.WriteAll(nojuice)
+				//Move the file, to the FailedFolder if anything went wrong; a retried file goes where it first would have
+				source := fullpath
+				if failure != nil && failure.Source != "" {
+					source = failure.Source
+				}
+				outPath := donePath(config.DoneFolder, source)
+				if retryEnabled() && run.failed() {
+					outPath = moveFailedFile(fullpath, run, failure)
+				} else {
+					if failure != nil {
+						log.WithFields(log.Fields{"File": fullpath, "Attempts": failure.Attempts + 1}).Info("Retried file processed")
+						clearFailure(fullpath)
+					}
+					if config.MoveAfterProcessed || failure != nil {
+						log.WithFields(log.Fields{
+							"src": fullpath,
+							"dst": outPath,
+						}).Debug("Moving File")
+						newDst, err := moveDoneFile(fullpath, outPath)
 						if err != nil {
-							log.WithError(err).Warn("Failure writing to NoSauceFile")
-						}
-
-						if err := writer.Error(); err != nil {
-							log.WithError(err).Warn("Failure writing to NoSauceFile")
+							log.WithFields(log.Fields{
+								"src": fullpath,
+								"dst": outPath,
+								"err": err,
+							}).Warning("Error moving File")
+						} else {
+							outPath = newDst
 						}
 					}
 				}
 
-				log.WithFields(log.Fields{"File": fullpath}).Info("File Processing Complete")
-
+				//a file in the FailedFolder is read again from its last checkpoint, its rows are written then
+				run.settleRows(retryEnabled() && run.failed())
+				run.complete(outPath)
 			}
 		}
 	}
@@ -391,14 +539,49 @@ func fileHandler(infileObj interface{}) {
 func waitFile(fullpath string) {
 	log.WithField("Fullpath", fullpath).Debug("New File Created")
 
-	duration := time.Second * time.Duration(config.WaitInterval)
-	log.WithField("Seconds", config.WaitInterval).Debug("Sleeping")
-	time.Sleep(duration)
+	if isReadinessArtifact(fullpath) {
+		log.WithField("Fullpath", fullpath).Debug("Skipping marker or partial file")
+		return
+	}
+
+	//a rotated tail file is the tailed file under a new name, the tailer already has it open
+	if isTailRotation(fullpath) {
+		log.WithField("Fullpath", fullpath).Debug("Skipping rotated tail file")
+		return
+	}
+	if isTailed(fullpath) {
+		startTail(fullpath)
+		return
+	}
+
+	prof := selectProfile(fullpath)
+	ready := waitReady(fullpath, prof.Readiness)
+	forgetFileActivity(fullpath)
+	if !ready {
+		log.WithField("Fullpath", fullpath).Debug("File went away before it was ready")
+		return
+	}
 
 	queueFile(fullpath)
 }
 
 func queueFile(fullpath string) {
+	if isShuttingDown() {
+		log.WithField("Fullpath", fullpath).Debug("Shutting down, leaving file for the next run")
+		return
+	}
+	//the size is known now that the file is ready, retries were selected the first time
+	if info, err := os.Stat(fullpath); err == nil && !isFailedFile(fullpath) && !isSelected(fullpath, info.Size()) {
+		return
+	}
+	if isTailRotation(fullpath) {
+		log.WithField("Fullpath", fullpath).Debug("Skipping rotated tail file")
+		return
+	}
+	if isTailed(fullpath) {
+		startTail(fullpath)
+		return
+	}
 	log.WithField("Fullpath", fullpath).Debug("Queueing File")
 	fileQueue.Push(fullpath)
 	lastInputActionTime = time.Now()
@@ -422,6 +605,18 @@ func main() {
 	//Load configuration
 	loadConfig(configFile)
 
+	if testRulesFile != "" {
+		if err := testRules(os.Stdout, testRulesFile, testRuleExpr); err != nil {
+			log.WithError(err).Fatal("Unable to test rules")
+		}
+		return
+	}
+
+	if explainPath != "" {
+		explainFile(os.Stdout, explainPath)
+		return
+	}
+
 	if config.IgnoreCertErrors {
 		http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
@@ -429,7 +624,18 @@ func main() {
 	//Initialization connection to ElasticSearch
 	initES()
 
+	//Load enrichment databases
+	initGeoIP()
+	initThreatIntel()
+	initReverseDNS()
+	initDedup()
+	initSummary()
+	initState()
+	initTail()
+	initJanitor()
+
 	fileQueue = oqueue.NewQueue(fileHandler, config.MaxConcurrentFiles)
+	initRetry()
 
 	go func() {
 		//Handle all the existing files
@@ -443,38 +649,17 @@ func main() {
 	go timerInputWatcher()
 	go timerOutputWatcher()
 
-	//Setup folder watcher
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.WithError(err).Fatal("Unable to create Folder Watcher")
-	}
-	defer watcher.Close()
-
-	done := make(chan bool)
-	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				if event.Op&fsnotify.Create == fsnotify.Create {
-					go waitFile(event.Name)
-				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				log.WithError(err).Warn("Error during folder watching")
-			}
-		}
-	}()
-
-	err = watcher.Add(config.WatchFolder)
+	//Setup folder watcher, for WatchFolder and all of its subfolders
+	watcher, err := newFolderWatcher(config.WatchFolder)
 	if err != nil {
 		log.WithError(err).Fatal("Unable to create folder watcher")
 	}
-	log.WithField("Folder", config.WatchFolder).Info("Watching Folder")
 
-	<-done
+	go watcher.run()
+	log.WithFields(log.Fields{"Folder": config.WatchFolder, "Mode": watcher.mode}).Info("Watching Folder")
+
+	//Run until stopped, then drain
+	sig := waitForSignal()
+	log.WithFields(log.Fields{"Signal": sig, "Timeout": config.ShutdownTimeout}).Warn("Shutting down")
+	os.Exit(shutdown(watcher, time.Second*time.Duration(config.ShutdownTimeout)))
 }
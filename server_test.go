@@ -20,13 +20,54 @@ func TestParseExtra(t *testing.T) {
 	records := make([]string, 0)
 	testValue := "a123b456c789d\r\ntesttesttest"
 
-	parseExtra(&testObj, records, testValue)
+	parseExtra(config.defaultProfile(), &testObj, nil, records, testValue)
 
 	assert.Equal(t, "123", testObj["test1"])
 	assert.Equal(t, "789", testObj["test2"])
 	assert.Equal(t, "sttesttest", testObj["test3"])
 }
 
+func TestParseExtra_all(t *testing.T) {
+	extra := make([]extraparsing, 2)
+	extra[0] = extraparsing{Name: "params", Start: "=", End: "&", Match: "all"}
+	extra[1] = extraparsing{Name: "first", Start: "=", End: "&"}
+	config = &configuration{ExtraParsing: extra}
+
+	testObj := make(map[string]interface{})
+	parseExtra(config.defaultProfile(), &testObj, nil, nil, "a=1&b=2&c=3")
+
+	assert.Equal(t, []string{"1", "2", "3"}, testObj["params"])
+	assert.Equal(t, "1", testObj["first"])
+
+	//the same delimiter on both sides
+	config = &configuration{ExtraParsing: []extraparsing{{Name: "quoted", Start: `"`, End: `"`, Match: "all"}}}
+	testObj = make(map[string]interface{})
+	parseExtra(config.defaultProfile(), &testObj, nil, nil, `a "one" b "two" c`)
+	assert.Equal(t, []string{"one", "two"}, testObj["quoted"])
+}
+
+func TestParseExtra_regex(t *testing.T) {
+	extra := make([]extraparsing, 4)
+	extra[0] = extraparsing{Name: "xff", Regex: `X-Forwarded-For: ([0-9.]+)`}
+	extra[1] = extraparsing{Name: "hosts", Regex: `Host: (\S+)`, Match: "all"}
+	extra[2] = extraparsing{Name: "req", Regex: `(?P<method>GET|POST) (?P<path>\S+)`}
+	extra[3] = extraparsing{Regex: `(?P<agent>[a-z]+)/(?P<version>[0-9.]+)`, Column: "ua"}
+	config = &configuration{ExtraParsing: extra}
+
+	headers := []string{"raw", "ua"}
+	records := []string{"", "curl/7.64.1"}
+	testValue := "GET /index.html\r\nHost: a.com\r\nX-Forwarded-For: 1.2.3.4\r\nHost: b.com"
+
+	testObj := make(map[string]interface{})
+	parseExtra(config.defaultProfile(), &testObj, headers, records, testValue)
+
+	assert.Equal(t, "1.2.3.4", testObj["xff"])
+	assert.Equal(t, []string{"a.com", "b.com"}, testObj["hosts"])
+	assert.Equal(t, map[string]interface{}{"method": "GET", "path": "/index.html"}, testObj["req"])
+	assert.Equal(t, "curl", testObj["agent"])
+	assert.Equal(t, "7.64.1", testObj["version"])
+}
+
 func TestShouldIgnore(t *testing.T) {
 	igList := make([]string, 4)
 	igList[0] = "test"
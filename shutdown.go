@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	//shutdownStarted - closed once no new files are being accepted
+	shutdownStarted = make(chan struct{})
+	//abandonFiles - closed at the deadline, files still being processed stop and checkpoint
+	abandonFiles = make(chan struct{})
+	//abandonGrace - how long files get to stop once they have been told to
+	abandonGrace = 15 * time.Second
+)
+
+func isShuttingDown() bool {
+	select {
+	case <-shutdownStarted:
+		return true
+	default:
+		return false
+	}
+}
+
+func isAbandoningFiles() bool {
+	select {
+	case <-abandonFiles:
+		return true
+	default:
+		return false
+	}
+}
+
+//waitForSignal - blocks until SIGINT or SIGTERM, a second signal exits straight away
+func waitForSignal() os.Signal {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	go func() {
+		sig := <-sigs
+		log.WithField("Signal", sig).Error("Second signal, exiting without draining")
+		os.Exit(2)
+	}()
+	return sig
+}
+
+//waitTimeout - calls wait, giving up after timeout; true if it returned in time
+func waitTimeout(wait func(), timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+//shutdown - stops accepting files, lets the ones being processed finish (or checkpoint them at the deadline),
+//then flushes every output. Returns the exit status: 0 if everything drained cleanly, 1 if not
+func shutdown(watcher *folderWatcher, timeout time.Duration) int {
+	clean := true
+	close(shutdownStarted)
+	if watcher != nil {
+		watcher.close()
+	}
+	stopRetries()
+
+	if fileQueue != nil {
+		//files waiting in the queue are left where they are for the next run
+		fileQueue.Stop()
+		if !waitTimeout(fileQueue.Wait, timeout) {
+			log.WithField("Timeout", timeout).Warn("Files still processing at the deadline, checkpointing them")
+			clean = false
+			close(abandonFiles)
+			if !waitTimeout(fileQueue.Wait, abandonGrace) {
+				log.Error("Files did not stop in time")
+			}
+		}
+	}
+	stopAllTails()
+
+	if config.Summary.Enabled {
+		emitAggregateSummary()
+	}
+	if err := flushQueue(); err != nil {
+		log.WithError(err).Error("Unable to flush ElasticSearch before exiting")
+		clean = false
+	}
+	saveDedup()
+
+	if !clean {
+		log.Warn("Shutdown did not drain cleanly")
+		return 1
+	}
+	log.Info("Shutdown complete")
+	return 0
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oqueue "github.com/otium/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetShutdown() {
+	shutdownStarted = make(chan struct{})
+	abandonFiles = make(chan struct{})
+	fileQueue = nil
+}
+
+func TestShutdown_clean(t *testing.T) {
+	defer resetShutdown()
+	config = createDefaultConfig()
+
+	var handled int32
+	fileQueue = oqueue.NewQueue(func(interface{}) {
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&handled, 1)
+	}, 1)
+	fileQueue.Push("a")
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 0, shutdown(nil, 2*time.Second))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&handled))
+	assert.True(t, isShuttingDown())
+	assert.False(t, isAbandoningFiles())
+}
+
+func TestShutdown_deadline(t *testing.T) {
+	defer resetShutdown()
+	config = createDefaultConfig()
+
+	var abandoned int32
+	fileQueue = oqueue.NewQueue(func(interface{}) {
+		<-abandonFiles
+		atomic.AddInt32(&abandoned, 1)
+	}, 1)
+	fileQueue.Push("a")
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 1, shutdown(nil, 50*time.Millisecond))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&abandoned))
+}
+
+func TestFileHandler_stopped(t *testing.T) {
+	defer resetShutdown()
+	watch, err := ioutil.TempDir("", "saucepan_input_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(watch)
+	done, err := ioutil.TempDir("", "saucepan_output_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(done)
+
+	config = createDefaultConfig()
+	config.WatchFolder = watch
+	config.DoneFolder = done
+	csvFile := filepath.Join(watch, "a_1.csv")
+	assert.NoError(t, ioutil.WriteFile(csvFile, []byte("1\n2\n"), 0644))
+
+	//a file being processed at the deadline is left for the next run
+	close(shutdownStarted)
+	close(abandonFiles)
+	fileHandler(csvFile)
+	assert.FileExists(t, csvFile)
+	assert.NoFileExists(t, filepath.Join(done, "a_1.csv"))
+}
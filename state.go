@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type stateConfig struct {
+	Enabled         bool   `json:"Enabled"`
+	File            string `json:"File"`
+	CheckpointLines int    `json:"CheckpointLines"`
+	RetentionDays   int    `json:"RetentionDays"`
+}
+
+//fileState - what is known about a file, keyed by the hash of its contents
+type fileState struct {
+	Path    string    `json:"Path"`
+	Size    int64     `json:"Size"`
+	Hash    string    `json:"Hash"`
+	Status  string    `json:"Status"`
+	Line    int       `json:"Line"`
+	Updated time.Time `json:"Updated"`
+}
+
+const (
+	stateProcessing = "processing"
+	stateDone       = "done"
+	stateFailed     = "failed"
+)
+
+//stateStore - the processing state of every file, saved to a JSON file after each change
+type stateStore struct {
+	lock  sync.Mutex
+	path  string
+	Files map[string]*fileState `json:"Files"`
+}
+
+var state *stateStore
+
+func initState() {
+	if !config.State.Enabled {
+		return
+	}
+	var err error
+	state, err = loadState(config.State.File)
+	if err != nil {
+		log.WithError(err).WithField("File", config.State.File).Fatal("Unable to load processing state")
+	}
+}
+
+func loadState(fullpath string) (*stateStore, error) {
+	s := &stateStore{path: fullpath, Files: make(map[string]*fileState)}
+	data, err := ioutil.ReadFile(fullpath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.Files == nil {
+		s.Files = make(map[string]*fileState)
+	}
+
+	//forget finished files after RetentionDays
+	if config.State.RetentionDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -config.State.RetentionDays)
+		for hash, fs := range s.Files {
+			if fs.Status == stateDone && fs.Updated.Before(cutoff) {
+				delete(s.Files, hash)
+			}
+		}
+	}
+	log.WithFields(log.Fields{"File": fullpath, "Files": len(s.Files)}).Info("Loaded processing state")
+	return s, nil
+}
+
+//saveLocked - writes the state via a temp file, so a crash never leaves it half written; the lock must be held
+func (s *stateStore) saveLocked() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+//hashFile - the SHA256 of the file's contents
+func hashFile(fullpath string) (string, int64, error) {
+	f, err := os.Open(fullpath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+//begin - looks the file up by its contents, starting a new entry if it has not been seen before
+func (s *stateStore) begin(fullpath string) (*fileState, error) {
+	hash, size, err := hashFile(fullpath)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fs, ok := s.Files[hash]
+	if !ok {
+		fs = &fileState{Hash: hash, Size: size, Status: stateProcessing}
+		s.Files[hash] = fs
+	} else if fs.Status != stateDone {
+		fs.Status = stateProcessing
+	}
+	fs.Path = fullpath
+	fs.Updated = time.Now()
+	ans := *fs
+	return &ans, s.saveLocked()
+}
+
+//update - saves a new status and/or checkpoint for a file
+func (s *stateStore) update(fs *fileState) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fs.Updated = time.Now()
+	saved := *fs
+	s.Files[fs.Hash] = &saved
+	return s.saveLocked()
+}
+
+//checkpoint - records that everything up to line has been acknowledged by the outputs, after flushing them;
+//false if the outputs could not be flushed
+func (s *stateStore) checkpoint(fs *fileState, line int) bool {
+	if err := flushFile(fs.Path); err != nil {
+		log.WithError(err).WithField("File", fs.Path).Warn("Outputs not flushed, not checkpointing")
+		return false
+	}
+	fs.Line = line
+	if err := s.update(fs); err != nil {
+		log.WithError(err).WithField("File", fs.Path).Warn("Unable to save processing state")
+	}
+	return true
+}
+
+//finish - marks the file done (or failed), once the outputs have been flushed
+func (s *stateStore) finish(fs *fileState, line int, failed bool) {
+	if !failed {
+		if err := flushFile(fs.Path); err != nil {
+			log.WithError(err).WithField("File", fs.Path).Warn("Outputs not flushed")
+			failed = true
+		}
+	}
+	if failed {
+		fs.Status = stateFailed
+	} else {
+		fs.Status = stateDone
+		fs.Line = line
+	}
+	if err := s.update(fs); err != nil {
+		log.WithError(err).WithField("File", fs.Path).Warn("Unable to save processing state")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateStore(t *testing.T) {
+	folder, err := ioutil.TempDir("", "saucepan_state_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	config = createDefaultConfig()
+	stateFile := filepath.Join(folder, "state.json")
+	csvFile := filepath.Join(folder, "a_1.csv")
+	assert.NoError(t, ioutil.WriteFile(csvFile, []byte("1\n2\n3\n"), 0644))
+
+	s, err := loadState(stateFile)
+	assert.NoError(t, err)
+	fs, err := s.begin(csvFile)
+	assert.NoError(t, err)
+	assert.Equal(t, stateProcessing, fs.Status)
+	assert.Equal(t, int64(6), fs.Size)
+	assert.Equal(t, 0, fs.Line)
+
+	s.checkpoint(fs, 2)
+
+	//a restart picks up the checkpoint
+	s, err = loadState(stateFile)
+	assert.NoError(t, err)
+	fs, err = s.begin(csvFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fs.Line)
+
+	s.finish(fs, 3, true)
+	fs, _ = s.begin(csvFile)
+	assert.Equal(t, stateProcessing, fs.Status)
+	assert.Equal(t, 2, fs.Line)
+
+	s.finish(fs, 3, false)
+
+	//the same contents under another name are recognised as done
+	copied := filepath.Join(folder, "b_1.csv")
+	assert.NoError(t, ioutil.WriteFile(copied, []byte("1\n2\n3\n"), 0644))
+	s, _ = loadState(stateFile)
+	fs, err = s.begin(copied)
+	assert.NoError(t, err)
+	assert.Equal(t, stateDone, fs.Status)
+	assert.Equal(t, 3, fs.Line)
+}
+
+func TestFileHandler_resume(t *testing.T) {
+	watch, err := ioutil.TempDir("", "saucepan_input_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(watch)
+	done, err := ioutil.TempDir("", "saucepan_output_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(done)
+
+	config = createDefaultConfig()
+	config.WatchFolder = watch
+	config.DoneFolder = done
+	config.CSVOptions.FirstRowHeader = true
+	config.Summary.Enabled = true
+	config.State.Enabled = true
+	config.State.File = filepath.Join(done, "state.json")
+	config.State.CheckpointLines = 2
+	initState()
+	defer func() { state = nil }()
+
+	csvFile := filepath.Join(watch, "a_1.csv")
+	assert.NoError(t, ioutil.WriteFile(csvFile, []byte("h\n1\n2\n3\n4\n5\n"), 0644))
+
+	//a previous run got as far as line 4 (the header is line 1)
+	fs, err := state.begin(csvFile)
+	assert.NoError(t, err)
+	fs.Line = 4
+	assert.NoError(t, state.update(fs))
+
+	fileHandler(csvFile)
+
+	data, err := ioutil.ReadFile(filepath.Join(done, "a_1.csv.summary.json"))
+	assert.NoError(t, err)
+	summary := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(data, &summary))
+	assert.Equal(t, 2.0, summary["Rows"])
+
+	fs, err = state.begin(filepath.Join(done, "a_1.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, stateDone, fs.Status)
+	assert.Equal(t, 6, fs.Line)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//subparserConfig - a declarative parser that explodes a column into nested fields
+type subparserConfig struct {
+	Type           string   `json:"Type"`
+	Column         string   `json:"Column"`
+	Prefix         string   `json:"Prefix"`
+	PairDelimiter  string   `json:"PairDelimiter"`
+	ValueDelimiter string   `json:"ValueDelimiter"`
+	Paths          []string `json:"Paths"`
+}
+
+func validateSubParsers(parsers []subparserConfig) {
+	for _, sp := range parsers {
+		switch sp.Type {
+		case "kv", "json", "url", "headers":
+		default:
+			log.WithFields(log.Fields{"Type": sp.Type, "Column": sp.Column}).Fatal("Invalid SubParser Type, must be kv, json, url or headers")
+		}
+	}
+}
+
+func runSubParsers(prof *profileConfig, obj map[string]interface{}, headers []string, record []string, captures []captureValue) {
+	for _, sp := range prof.SubParsers {
+		var value string
+		if sp.Column != "" {
+			idx := resolveColumn(sp.Column, headers)
+			if idx < 0 || idx >= len(record) {
+				continue
+			}
+			value = record[idx]
+		} else if len(captures) > 0 {
+			value = captures[0].Value
+		}
+		if value == "" {
+			continue
+		}
+
+		var fields map[string]interface{}
+		switch sp.Type {
+		case "kv":
+			fields = parseKeyValue(value, sp.PairDelimiter, sp.ValueDelimiter)
+		case "json":
+			fields = parseJSONBlob(value, sp.Paths)
+		case "url":
+			fields = parseURL(value)
+		case "headers":
+			fields = parseHTTPHeaders(value)
+		default:
+			log.WithField("Type", sp.Type).Warn("Unknown SubParser type")
+			continue
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+		if sp.Prefix == "" {
+			//added alongside the record's own fields, never over them (FileName, Line, Tag, the columns, ...)
+			for k, v := range fields {
+				if _, exists := obj[k]; exists {
+					log.WithFields(log.Fields{"Type": sp.Type, "Field": k}).Debug("SubParser field already in the record, skipped")
+					continue
+				}
+				obj[k] = v
+			}
+		} else {
+			obj[sp.Prefix] = fields
+		}
+	}
+}
+
+//addMultiValue - sets key to value, turning it into an array if the key repeats
+func addMultiValue(fields map[string]interface{}, key string, value string) {
+	switch existing := fields[key].(type) {
+	case nil:
+		fields[key] = value
+	case string:
+		fields[key] = []string{existing, value}
+	case []string:
+		fields[key] = append(existing, value)
+	}
+}
+
+func parseKeyValue(value string, pairDelim string, valueDelim string) map[string]interface{} {
+	if pairDelim == "" {
+		pairDelim = "&"
+	}
+	if valueDelim == "" {
+		valueDelim = "="
+	}
+
+	fields := make(map[string]interface{})
+	for _, pair := range strings.Split(value, pairDelim) {
+		parts := strings.SplitN(pair, valueDelim, 2)
+		key := strings.TrimSpace(parts[0])
+		if key == "" {
+			continue
+		}
+		val := ""
+		if len(parts) > 1 {
+			val = strings.TrimSpace(parts[1])
+		}
+		addMultiValue(fields, key, val)
+	}
+	return fields
+}
+
+//lookupPath - walks a dotted path (e.g. "request.headers.0.name") through decoded json
+func lookupPath(doc interface{}, path string) (interface{}, bool) {
+	current := doc
+	for _, segment := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			next, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			idx, err := strconv.Atoi(segment)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			current = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func parseJSONBlob(value string, paths []string) map[string]interface{} {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(value), &doc); err != nil {
+		log.WithError(err).Debug("SubParser value is not json")
+		return nil
+	}
+
+	if len(paths) == 0 {
+		if fields, ok := doc.(map[string]interface{}); ok {
+			return fields
+		}
+		return map[string]interface{}{"value": doc}
+	}
+
+	fields := make(map[string]interface{})
+	for _, p := range paths {
+		if v, ok := lookupPath(doc, p); ok {
+			fields[p] = v
+		}
+	}
+	return fields
+}
+
+func parseURL(value string) map[string]interface{} {
+	u, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		log.WithError(err).Debug("SubParser value is not a url")
+		return nil
+	}
+
+	fields := make(map[string]interface{})
+	setIfNotEmpty := func(key string, val string) {
+		if val != "" {
+			fields[key] = val
+		}
+	}
+	setIfNotEmpty("scheme", u.Scheme)
+	setIfNotEmpty("host", u.Host)
+	setIfNotEmpty("hostname", u.Hostname())
+	setIfNotEmpty("port", u.Port())
+	setIfNotEmpty("path", u.Path)
+	setIfNotEmpty("query", u.RawQuery)
+	setIfNotEmpty("fragment", u.Fragment)
+	if u.User != nil {
+		setIfNotEmpty("user", u.User.Username())
+	}
+
+	if params, err := url.ParseQuery(u.RawQuery); err == nil && len(params) > 0 {
+		p := make(map[string]interface{})
+		for k, vals := range params {
+			for _, v := range vals {
+				addMultiValue(p, k, v)
+			}
+		}
+		fields["params"] = p
+	}
+	return fields
+}
+
+//parseHTTPHeaders - parses a raw header block, including an optional request or status line
+func parseHTTPHeaders(value string) map[string]interface{} {
+	fields := make(map[string]interface{})
+	headers := make(map[string]interface{})
+
+	lines := strings.Split(strings.Replace(value, "\r\n", "\n", -1), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		idx := strings.Index(line, ":")
+		if i == 0 && (idx < 0 || strings.HasPrefix(line, "HTTP/") || strings.Contains(line[:idx], " ")) {
+			parts := strings.SplitN(line, " ", 3)
+			if strings.HasPrefix(parts[0], "HTTP/") {
+				fields["protocol"] = parts[0]
+				if len(parts) > 1 {
+					fields["status"] = parts[1]
+				}
+				if len(parts) > 2 {
+					fields["reason"] = parts[2]
+				}
+			} else {
+				fields["method"] = parts[0]
+				if len(parts) > 1 {
+					fields["path"] = parts[1]
+				}
+				if len(parts) > 2 {
+					fields["protocol"] = parts[2]
+				}
+			}
+			continue
+		}
+		if idx <= 0 {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSpace(line[:idx]))
+		addMultiValue(headers, name, strings.TrimSpace(line[idx+1:]))
+	}
+
+	if len(headers) > 0 {
+		fields["headers"] = headers
+	}
+	return fields
+}
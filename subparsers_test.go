@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeyValue(t *testing.T) {
+	fields := parseKeyValue("a=1; b = 2;a=3;flag", ";", "")
+	assert.Equal(t, map[string]interface{}{"a": []string{"1", "3"}, "b": "2", "flag": ""}, fields)
+}
+
+func TestParseJSONBlob(t *testing.T) {
+	blob := `{"user": {"name": "bob", "roles": ["admin", "dev"]}, "id": 7}`
+
+	assert.Equal(t, map[string]interface{}{"user.name": "bob", "user.roles.1": "dev"}, parseJSONBlob(blob, []string{"user.name", "user.roles.1", "missing"}))
+	assert.Equal(t, float64(7), parseJSONBlob(blob, nil)["id"])
+	assert.Nil(t, parseJSONBlob("not json", nil))
+}
+
+func TestParseURL(t *testing.T) {
+	fields := parseURL("https://bob@example.com:8443/a/b?x=1&x=2&y=3#frag")
+
+	assert.Equal(t, "https", fields["scheme"])
+	assert.Equal(t, "example.com", fields["hostname"])
+	assert.Equal(t, "8443", fields["port"])
+	assert.Equal(t, "/a/b", fields["path"])
+	assert.Equal(t, "frag", fields["fragment"])
+	assert.Equal(t, "bob", fields["user"])
+	assert.Equal(t, map[string]interface{}{"x": []string{"1", "2"}, "y": "3"}, fields["params"])
+}
+
+func TestParseHTTPHeaders(t *testing.T) {
+	fields := parseHTTPHeaders("POST /login HTTP/1.1\r\nHost: example.com\r\nCookie: a=1\r\nCookie: b=2\r\n\r\n")
+
+	assert.Equal(t, "POST", fields["method"])
+	assert.Equal(t, "/login", fields["path"])
+	assert.Equal(t, "HTTP/1.1", fields["protocol"])
+	assert.Equal(t, map[string]interface{}{"host": "example.com", "cookie": []string{"a=1", "b=2"}}, fields["headers"])
+}
+
+func TestRunSubParsers(t *testing.T) {
+	config = createDefaultConfig()
+	config.SubParsers = []subparserConfig{
+		{Type: "url", Column: "url", Prefix: "url_parts"},
+		{Type: "kv", Prefix: "capture"},
+	}
+
+	obj := make(map[string]interface{})
+	headers := []string{"url", "data"}
+	record := []string{"http://example.com/?q=1", "k=v"}
+	runSubParsers(config.defaultProfile(), obj, headers, record, []captureValue{{Column: "data", Value: "k=v"}})
+
+	assert.Equal(t, "example.com", obj["url_parts"].(map[string]interface{})["host"])
+	assert.Equal(t, map[string]interface{}{"k": "v"}, obj["capture"])
+
+	//without a Prefix the parsed keys never replace the record's own fields
+	config.SubParsers = []subparserConfig{{Type: "kv", Column: "data"}}
+	obj = map[string]interface{}{"FileName": "proxy_1.csv", "Line": 2, "data": "x"}
+	runSubParsers(config.defaultProfile(), obj, headers, []string{"", "FileName=evil.csv&Line=9&user=bob"}, nil)
+	assert.Equal(t, "proxy_1.csv", obj["FileName"])
+	assert.Equal(t, 2, obj["Line"])
+	assert.Equal(t, "bob", obj["user"])
+}
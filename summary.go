@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type summaryConfig struct {
+	Enabled           bool   `json:"Enabled"`
+	IndexStart        string `json:"IndexStart"`
+	WriteFile         bool   `json:"WriteFile"`
+	TopHits           int    `json:"TopHits"`
+	AggregateInterval int    `json:"AggregateInterval"`
+}
+
+//hitCount - a hit value and how many times it was seen
+type hitCount struct {
+	Value string `json:"Value"`
+	Count int    `json:"Count"`
+}
+
+//latencyStats - timings (in milliseconds) of the CyberSaucier calls
+type latencyStats struct {
+	Calls  int     `json:"Calls"`
+	Errors int     `json:"Errors"`
+	MinMs  float64 `json:"MinMs"`
+	MaxMs  float64 `json:"MaxMs"`
+	AvgMs  float64 `json:"AvgMs"`
+	P95Ms  float64 `json:"P95Ms"`
+}
+
+//ingestSummary - the counts for one file ("file"), or for every file finished in an interval ("aggregate")
+type ingestSummary struct {
+	Type         string         `json:"Type"`
+	Name         string         `json:"Name"`
+	File         string         `json:"File,omitempty"`
+	Profile      string         `json:"Profile,omitempty"`
+	Tag          string         `json:"Tag,omitempty"`
+	Files        int            `json:"Files"`
+	Started      time.Time      `json:"Started"`
+	Finished     time.Time      `json:"Finished"`
+	DurationMs   int64          `json:"DurationMs"`
+	Rows         int            `json:"Rows"`
+	ParseErrors  int            `json:"ParseErrors"`
+	Juice        int            `json:"Juice"`
+	NoSauce      int            `json:"NoSauce"`
+	Duplicates   int            `json:"Duplicates"`
+	Sampled      int            `json:"Sampled"`
+	Dropped      int            `json:"Dropped"`
+	RecipeHits   map[string]int `json:"RecipeHits"`
+	TopHits      []hitCount     `json:"TopHits"`
+	CyberSaucier latencyStats   `json:"CyberSaucier"`
+
+	hitCounts map[string]int
+	latencies latencySample
+	errors    int
+}
+
+//maxLatencySamples - how many call timings are kept for the P95, beyond it they are a uniform sample
+const maxLatencySamples = 1024
+
+//latencySample - the count, min, max and total of every call, and a fixed size reservoir of them for the P95
+type latencySample struct {
+	count   int
+	min     float64
+	max     float64
+	total   float64
+	samples []float64
+}
+
+//add - records one call, once the reservoir is full each call replaces a random sample with probability size/count
+func (l *latencySample) add(ms float64) {
+	l.observe(1, ms, ms, ms)
+	l.offer(ms)
+}
+
+func (l *latencySample) observe(count int, min float64, max float64, total float64) {
+	if l.count == 0 || min < l.min {
+		l.min = min
+	}
+	if l.count == 0 || max > l.max {
+		l.max = max
+	}
+	l.count += count
+	l.total += total
+}
+
+func (l *latencySample) offer(ms float64) {
+	if len(l.samples) < maxLatencySamples {
+		l.samples = append(l.samples, ms)
+	} else if i := rand.Intn(l.count); i < maxLatencySamples {
+		l.samples[i] = ms
+	}
+}
+
+//merge - adds another sample, each of its samples standing in for count/len(samples) calls
+func (l *latencySample) merge(other latencySample) {
+	if other.count == 0 {
+		return
+	}
+	if l.count+other.count <= maxLatencySamples {
+		l.observe(other.count, other.min, other.max, other.total)
+		l.samples = append(l.samples, other.samples...)
+		return
+	}
+	weight := float64(other.count) / float64(len(other.samples))
+	seen := float64(l.count)
+	for _, ms := range other.samples {
+		seen += weight
+		if len(l.samples) < maxLatencySamples {
+			l.samples = append(l.samples, ms)
+		} else if i := rand.Int63n(int64(seen)); i < maxLatencySamples {
+			l.samples[i] = ms
+		}
+	}
+	l.observe(other.count, other.min, other.max, other.total)
+}
+
+var (
+	aggregate     *ingestSummary
+	aggregateLock sync.Mutex
+)
+
+func newIngestSummary(kind string) *ingestSummary {
+	return &ingestSummary{
+		Type:       kind,
+		Name:       config.Name,
+		Started:    time.Now(),
+		RecipeHits: make(map[string]int),
+		TopHits:    make([]hitCount, 0),
+		hitCounts:  make(map[string]int),
+	}
+}
+
+func initSummary() {
+	if !config.Summary.Enabled {
+		return
+	}
+	aggregate = newIngestSummary("aggregate")
+	startReloader(config.Summary.AggregateInterval, emitAggregateSummary)
+}
+
+//addSauceCall - records the time a CyberSaucier (or native recipe) call took
+func (s *ingestSummary) addSauceCall(elapsed time.Duration, err error) {
+	s.latencies.add(float64(elapsed) / float64(time.Millisecond))
+	if err != nil {
+		s.errors++
+	}
+}
+
+//addJuice - counts the recipes and hit values of a (redacted) record that is being sent on
+func (s *ingestSummary) addJuice(obj map[string]interface{}) {
+	s.Juice++
+	if items, ok := obj["CyberSaucier"].([]interface{}); ok {
+		for _, item := range items {
+			if cs, ok := item.(map[string]interface{}); ok {
+				name, _ := cs["recipeName"].(string)
+				result, _ := cs["result"].(string)
+				if name != "" && result != "" {
+					s.RecipeHits[name] += len(strings.Split(result, "\n"))
+				}
+			}
+		}
+	}
+	for _, hit := range fieldStrings(obj["Hits"]) {
+		s.hitCounts[hit]++
+	}
+}
+
+//merge - adds a finished file's counts to this (aggregate) summary
+func (s *ingestSummary) merge(other *ingestSummary) {
+	s.Files++
+	s.Rows += other.Rows
+	s.ParseErrors += other.ParseErrors
+	s.Juice += other.Juice
+	s.NoSauce += other.NoSauce
+	s.Duplicates += other.Duplicates
+	s.Sampled += other.Sampled
+	s.Dropped += other.Dropped
+	for name, n := range other.RecipeHits {
+		s.RecipeHits[name] += n
+	}
+	for hit, n := range other.hitCounts {
+		s.hitCounts[hit] += n
+	}
+	s.latencies.merge(other.latencies)
+	s.errors += other.errors
+}
+
+//finish - works out the duration, top hits and latency stats
+func (s *ingestSummary) finish() {
+	s.Finished = time.Now()
+	s.DurationMs = int64(s.Finished.Sub(s.Started) / time.Millisecond)
+
+	s.TopHits = make([]hitCount, 0, len(s.hitCounts))
+	for value, n := range s.hitCounts {
+		s.TopHits = append(s.TopHits, hitCount{Value: value, Count: n})
+	}
+	sort.Slice(s.TopHits, func(i, j int) bool {
+		if s.TopHits[i].Count != s.TopHits[j].Count {
+			return s.TopHits[i].Count > s.TopHits[j].Count
+		}
+		return s.TopHits[i].Value < s.TopHits[j].Value
+	})
+	if config.Summary.TopHits >= 0 && len(s.TopHits) > config.Summary.TopHits {
+		s.TopHits = s.TopHits[:config.Summary.TopHits]
+	}
+
+	stats := latencyStats{Calls: s.latencies.count, Errors: s.errors}
+	if s.latencies.count > 0 {
+		sorted := append([]float64(nil), s.latencies.samples...)
+		sort.Float64s(sorted)
+		stats.MinMs = s.latencies.min
+		stats.MaxMs = s.latencies.max
+		stats.AvgMs = s.latencies.total / float64(s.latencies.count)
+		stats.P95Ms = sorted[int(math.Ceil(float64(len(sorted))*0.95))-1]
+	}
+	s.CyberSaucier = stats
+}
+
+//send - indexes the summary in ES
+func (s *ingestSummary) send() {
+	data, err := json.Marshal(s)
+	if err != nil {
+		log.WithError(err).Warn("Error marshalling summary to json")
+		return
+	}
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(data, &doc); err != nil {
+		log.WithError(err).Warn("Error marshalling summary to json")
+		return
+	}
+	if err := sendDataToES("", config.Summary.IndexStart, doc); err != nil {
+		log.WithError(err).Warn("Error sending summary to ElasticSearch")
+	}
+}
+
+//writeFileSummary - finishes a file's summary, then writes it next to the processed file and to ES
+func writeFileSummary(s *ingestSummary, outFolder string, filename string) {
+	if !config.Summary.Enabled {
+		return
+	}
+	s.finish()
+
+	if config.Summary.WriteFile {
+		outFile := filepath.Join(outFolder, filename+".summary.json")
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err == nil {
+			err = os.MkdirAll(outFolder, 0755)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(outFile, data, 0644)
+		}
+		if err != nil {
+			log.WithError(err).WithField("File", outFile).Warn("Error writing summary file")
+		}
+	}
+	s.send()
+
+	aggregateLock.Lock()
+	if aggregate != nil {
+		aggregate.merge(s)
+	}
+	aggregateLock.Unlock()
+}
+
+//emitAggregateSummary - sends the totals for the files finished since the last one, then starts over
+func emitAggregateSummary() {
+	aggregateLock.Lock()
+	s := aggregate
+	if s == nil || s.Files == 0 {
+		aggregateLock.Unlock()
+		return
+	}
+	aggregate = newIngestSummary("aggregate")
+	aggregateLock.Unlock()
+
+	s.finish()
+	s.send()
+}
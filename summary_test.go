@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIngestSummary(t *testing.T) {
+	config = createDefaultConfig()
+	config.Summary.TopHits = 2
+
+	s := newIngestSummary("file")
+	for i := 1; i <= 20; i++ {
+		s.addSauceCall(time.Duration(i)*time.Millisecond, nil)
+	}
+	s.addSauceCall(time.Millisecond, errors.New("timeout"))
+
+	s.addJuice(map[string]interface{}{
+		"Hits": []string{"1.2.3.4", "evil.com"},
+		"CyberSaucier": []interface{}{
+			map[string]interface{}{"recipeName": "Extract IP addresses", "result": "1.2.3.4"},
+			map[string]interface{}{"recipeName": "Extract domains", "result": "evil.com"},
+		},
+	})
+	s.addJuice(map[string]interface{}{
+		"Hits": []string{"evil.com", "evil.com", "a.com"},
+		"CyberSaucier": []interface{}{
+			map[string]interface{}{"recipeName": "Extract domains", "result": "evil.com\nevil.com\na.com"},
+		},
+	})
+	s.finish()
+
+	assert.Equal(t, 2, s.Juice)
+	assert.Equal(t, map[string]int{"Extract IP addresses": 1, "Extract domains": 4}, s.RecipeHits)
+	assert.Equal(t, []hitCount{{Value: "evil.com", Count: 3}, {Value: "1.2.3.4", Count: 1}}, s.TopHits)
+	assert.Equal(t, 21, s.CyberSaucier.Calls)
+	assert.Equal(t, 1, s.CyberSaucier.Errors)
+	assert.Equal(t, 1.0, s.CyberSaucier.MinMs)
+	assert.Equal(t, 20.0, s.CyberSaucier.MaxMs)
+	assert.Equal(t, 19.0, s.CyberSaucier.P95Ms)
+
+	agg := newIngestSummary("aggregate")
+	agg.merge(s)
+	agg.merge(s)
+	agg.finish()
+	assert.Equal(t, 2, agg.Files)
+	assert.Equal(t, 4, agg.Juice)
+	assert.Equal(t, 8, agg.RecipeHits["Extract domains"])
+	assert.Equal(t, 42, agg.CyberSaucier.Calls)
+}
+
+func TestIngestSummary_latencyBounded(t *testing.T) {
+	config = createDefaultConfig()
+
+	s := newIngestSummary("file")
+	for i := 1; i <= 100000; i++ {
+		s.addSauceCall(time.Duration(i)*time.Millisecond, nil)
+	}
+	assert.Len(t, s.latencies.samples, maxLatencySamples)
+
+	agg := newIngestSummary("aggregate")
+	agg.merge(s)
+	agg.merge(s)
+	assert.Len(t, agg.latencies.samples, maxLatencySamples)
+
+	for _, summary := range []*ingestSummary{s, agg} {
+		summary.finish()
+		assert.Equal(t, 1.0, summary.CyberSaucier.MinMs)
+		assert.Equal(t, 100000.0, summary.CyberSaucier.MaxMs)
+		assert.InDelta(t, 50000.5, summary.CyberSaucier.AvgMs, 0.001)
+		assert.InDelta(t, 95000, summary.CyberSaucier.P95Ms, 3000)
+	}
+	assert.Equal(t, 200000, agg.CyberSaucier.Calls)
+}
+
+func TestWriteFileSummary(t *testing.T) {
+	folder, err := ioutil.TempDir("", "saucepan_summary_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	config = createDefaultConfig()
+	config.Summary.Enabled = true
+	aggregate = newIngestSummary("aggregate")
+
+	s := newIngestSummary("file")
+	s.File = "/watch/proxy_20190101.csv"
+	s.Rows = 5
+	s.NoSauce = 5
+	writeFileSummary(s, folder, "proxy_20190101.csv")
+
+	data, err := ioutil.ReadFile(filepath.Join(folder, "proxy_20190101.csv.summary.json"))
+	assert.NoError(t, err)
+	doc := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, "file", doc["Type"])
+	assert.Equal(t, 5.0, doc["Rows"])
+	assert.Equal(t, 1, aggregate.Files)
+	assert.Equal(t, 5, aggregate.Rows)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type tailConfig struct {
+	Enabled      bool `json:"Enabled"`
+	PollInterval int  `json:"PollInterval"`
+}
+
+//tailOffset - how far into a tailed file the outputs have acknowledged
+type tailOffset struct {
+	Inode   uint64   `json:"Inode"`
+	Offset  int64    `json:"Offset"`
+	Line    int      `json:"Line"`
+	Headers []string `json:"Headers"`
+}
+
+//tailer - follows one growing file, like tail -F
+type tailer struct {
+	fullpath string
+	prof     *profileConfig
+	f        *os.File
+	inode    uint64
+	offset   int64
+	line     int
+	headers  []string
+	run      *fileRun
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+var (
+	tailers     = make(map[string]*tailer)
+	tailersLock sync.Mutex
+
+	tailOffsets     = make(map[string]tailOffset)
+	tailOffsetsLock sync.Mutex
+)
+
+func initTail() {
+	if config.TailStateFile == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(config.TailStateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("File", config.TailStateFile).Warn("Unable to load tail offsets")
+		}
+		return
+	}
+	tailOffsetsLock.Lock()
+	defer tailOffsetsLock.Unlock()
+	if err := json.Unmarshal(data, &tailOffsets); err != nil {
+		log.WithError(err).WithField("File", config.TailStateFile).Warn("Unable to load tail offsets")
+	}
+}
+
+//saveTailOffset - records (and persists) the acknowledged offset of a tailed file
+func saveTailOffset(fullpath string, offset tailOffset) {
+	tailOffsetsLock.Lock()
+	defer tailOffsetsLock.Unlock()
+	tailOffsets[fullpath] = offset
+	if config.TailStateFile == "" {
+		return
+	}
+
+	data, err := json.Marshal(tailOffsets)
+	if err == nil {
+		tmp := config.TailStateFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, config.TailStateFile)
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithField("File", config.TailStateFile).Warn("Unable to save tail offsets")
+	}
+}
+
+func getTailOffset(fullpath string) (tailOffset, bool) {
+	tailOffsetsLock.Lock()
+	defer tailOffsetsLock.Unlock()
+	offset, ok := tailOffsets[fullpath]
+	return offset, ok
+}
+
+//isTailed - true if the file's profile follows it instead of processing it once
+func isTailed(fullpath string) bool {
+	return selectProfile(fullpath).Tail.Enabled
+}
+
+//isTailRotation - true if the file is one being tailed under another name (i.e. it was just rotated),
+//the tailer finishes and moves it so it must not also be processed as a new file
+func isTailRotation(fullpath string) bool {
+	info, err := os.Stat(fullpath)
+	if err != nil {
+		return false
+	}
+	inode := fileInode(info)
+	if inode == 0 {
+		return false
+	}
+
+	tailersLock.Lock()
+	defer tailersLock.Unlock()
+	for name, t := range tailers {
+		if name != fullpath && t.inode == inode {
+			return true
+		}
+	}
+	return false
+}
+
+//startTail - starts following the file, unless it is already being followed
+func startTail(fullpath string) {
+	tailersLock.Lock()
+	defer tailersLock.Unlock()
+	if _, ok := tailers[fullpath]; ok {
+		return
+	}
+
+	t := &tailer{
+		fullpath: fullpath,
+		prof:     selectProfile(fullpath),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	tailers[fullpath] = t
+	log.WithFields(log.Fields{"File": fullpath, "Profile": t.prof.Name}).Info("Tailing file")
+	go t.follow()
+}
+
+//stopAllTails - stops every tailer, after it has finished the lines it is working on
+func stopAllTails() {
+	tailersLock.Lock()
+	all := make([]*tailer, 0, len(tailers))
+	for _, t := range tailers {
+		all = append(all, t)
+	}
+	tailersLock.Unlock()
+
+	for _, t := range all {
+		close(t.stop)
+		<-t.stopped
+	}
+}
+
+func (t *tailer) follow() {
+	defer close(t.stopped)
+	defer func() {
+		tailersLock.Lock()
+		delete(tailers, t.fullpath)
+		tailersLock.Unlock()
+	}()
+
+	interval := time.Second * time.Duration(t.prof.Tail.PollInterval)
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		if !t.poll() {
+			return
+		}
+		select {
+		case <-t.stop:
+			t.closeFile(false)
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+//open - opens the file at its path, resuming from the saved offset if it is the same file
+func (t *tailer) open() error {
+	f, err := os.Open(t.fullpath)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	t.f = f
+	tailersLock.Lock()
+	t.inode = fileInode(info)
+	tailersLock.Unlock()
+	t.offset = 0
+	t.line = 0
+	t.headers = nil
+	if saved, ok := getTailOffset(t.fullpath); ok && saved.Inode == t.inode && saved.Offset <= info.Size() {
+		t.offset = saved.Offset
+		t.line = saved.Line
+		t.headers = saved.Headers
+	}
+	t.run = newFileRun(t.fullpath, t.prof, t.headers, 0)
+	return nil
+}
+
+//closeFile - finishes with the current file; a rotated file is then moved like any other processed file
+func (t *tailer) closeFile(rotated bool) {
+	if t.f == nil {
+		return
+	}
+	t.f.Close()
+	t.f = nil
+	if !rotated {
+		return
+	}
+
+	outPath := donePath(config.DoneFolder, t.fullpath)
+	if config.MoveAfterProcessed && t.inode != 0 {
+		//find the rotated file by its inode, it is usually still next to the file being tailed
+		dir := filepath.Dir(t.fullpath)
+		if infos, err := ioutil.ReadDir(dir); err == nil {
+			for _, info := range infos {
+				if !info.IsDir() && fileInode(info) == t.inode {
+					src := filepath.Join(dir, info.Name())
+					dst := donePath(config.DoneFolder, src)
+					log.WithFields(log.Fields{"src": src, "dst": dst}).Debug("Moving Rotated File")
+					if moved, err := moveDoneFile(src, dst); err != nil {
+						log.WithFields(log.Fields{"src": src, "dst": dst, "err": err}).Warning("Error moving File")
+					} else {
+						outPath = moved
+					}
+					break
+				}
+			}
+		}
+	}
+	t.run.complete(outPath)
+}
+
+//poll - reads whatever has been appended, handling truncation and rotation; false once the file is gone
+func (t *tailer) poll() bool {
+	info, statErr := os.Stat(t.fullpath)
+
+	if t.f != nil {
+		if statErr != nil || fileInode(info) != t.inode {
+			//rotated (or removed): finish the old file, then start on the new one
+			t.read()
+			log.WithField("File", t.fullpath).Info("Tailed file rotated")
+			t.closeFile(true)
+		} else if info.Size() < t.offset {
+			log.WithField("File", t.fullpath).Info("Tailed file truncated")
+			t.offset = 0
+			t.line = 0
+			t.headers = nil
+			t.run.headers = nil
+		}
+	}
+
+	if t.f == nil {
+		if statErr != nil {
+			log.WithField("File", t.fullpath).Info("Tailed file is gone, no longer tailing")
+			return false
+		}
+		if err := t.open(); err != nil {
+			log.WithError(err).WithField("File", t.fullpath).Warn("Could not open file")
+			return false
+		}
+	}
+
+	t.read()
+	return true
+}
+
+//read - processes the complete lines after the offset, the last partial line is left for the next poll
+func (t *tailer) read() {
+	if _, err := t.f.Seek(t.offset, io.SeekStart); err != nil {
+		log.WithError(err).WithField("File", t.fullpath).Warn("Could not seek in tailed file")
+		return
+	}
+	data, err := ioutil.ReadAll(t.f)
+	if err != nil {
+		log.WithError(err).WithField("File", t.fullpath).Warn("Could not read tailed file")
+		return
+	}
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return
+	}
+	data = data[:end+1]
+	t.run.outputFailed = false
+	forgetESFailures(t.fullpath)
+
+	reader := newCSVReader(t.prof, bytes.NewReader(data))
+	t.run.comma = reader.Comma
+	line := t.line
+	headers := t.headers
+	if t.headers == nil {
+		var consumed int
+		t.headers, consumed = readHeaders(t.prof, reader)
+		line += consumed
+		t.run.headers = t.headers
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		t.run.processRecord(line, record, err)
+	}
+
+	if err := flushFile(t.fullpath); err != nil {
+		t.run.outputFailed = true
+	}
+	t.run.settleDedup(t.run.outputFailed)
+	t.run.settleRows(t.run.outputFailed)
+
+	//the same lines (and header) are read again on the next poll, until the outputs take them
+	if t.run.outputFailed {
+		log.WithFields(log.Fields{"File": t.fullpath, "Offset": t.offset}).Warn("Outputs failed, tailed lines will be read again")
+		t.headers = headers
+		t.run.headers = headers
+		return
+	}
+	t.offset += int64(len(data))
+	t.line = line
+	saveTailOffset(t.fullpath, tailOffset{Inode: t.inode, Offset: t.offset, Line: t.line, Headers: t.headers})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func appendToFile(t *testing.T, fullpath string, data string) {
+	f, err := os.OpenFile(fullpath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(data)
+	assert.NoError(t, err)
+	f.Close()
+}
+
+func TestTailer(t *testing.T) {
+	watch, err := ioutil.TempDir("", "saucepan_input_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(watch)
+	done, err := ioutil.TempDir("", "saucepan_output_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(done)
+
+	config = createDefaultConfig()
+	config.WatchFolder = watch
+	config.DoneFolder = done
+	config.TailStateFile = filepath.Join(done, "tail.json")
+	config.CSVOptions.FirstRowHeader = true
+	config.Profiles = []profileConfig{{Name: "live", FileGlob: "live_*.csv", Tail: &tailConfig{Enabled: true, PollInterval: 1}}}
+	tailOffsets = make(map[string]tailOffset)
+
+	fullpath := filepath.Join(watch, "live_1.csv")
+	assert.True(t, isTailed(fullpath))
+	assert.False(t, isTailed(filepath.Join(watch, "other_1.csv")))
+
+	appendToFile(t, fullpath, "src,dst\n1,2\n3,")
+	tl := &tailer{fullpath: fullpath, prof: selectProfile(fullpath)}
+	assert.True(t, tl.poll())
+	assert.Equal(t, []string{"src", "dst"}, tl.headers)
+	assert.Equal(t, 1, tl.run.summary.Rows)
+	assert.Equal(t, int64(len("src,dst\n1,2\n")), tl.offset)
+
+	//the partial line is read once it is complete
+	appendToFile(t, fullpath, "4\n5,6\n")
+	assert.True(t, tl.poll())
+	assert.Equal(t, 3, tl.run.summary.Rows)
+	assert.Equal(t, 4, tl.line)
+
+	//the offset survives a restart
+	tailOffsets = make(map[string]tailOffset)
+	initTail()
+	saved, ok := getTailOffset(fullpath)
+	assert.True(t, ok)
+	assert.Equal(t, tl.offset, saved.Offset)
+	assert.Equal(t, []string{"src", "dst"}, saved.Headers)
+
+	//truncation starts over, header included
+	assert.NoError(t, ioutil.WriteFile(fullpath, []byte("a,b\n7,8\n"), 0644))
+	assert.True(t, tl.poll())
+	assert.Equal(t, []string{"a", "b"}, tl.headers)
+	assert.Equal(t, 4, tl.run.summary.Rows)
+
+	//rotation: the rest of the old file is read, it is moved, and the new file is followed
+	appendToFile(t, fullpath, "9,10\n")
+	rotated := filepath.Join(watch, "live_1.csv.1")
+	assert.NoError(t, os.Rename(fullpath, rotated))
+	appendToFile(t, fullpath, "x,y\n11,12\n")
+	oldRun := tl.run
+	assert.True(t, tl.poll())
+	assert.Equal(t, 5, oldRun.summary.Rows)
+	assert.Equal(t, 1, tl.run.summary.Rows)
+	assert.Equal(t, []string{"x", "y"}, tl.headers)
+	_, err = os.Stat(filepath.Join(done, "live_1.csv.1"))
+	assert.NoError(t, err)
+
+	//once the file is gone, the tailer stops
+	tl.closeFile(false)
+	assert.NoError(t, os.Remove(fullpath))
+	assert.False(t, tl.poll())
+}
+
+func TestTailer_outputFailed(t *testing.T) {
+	watch, err := ioutil.TempDir("", "saucepan_input_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(watch)
+
+	down := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"status":201}},{"index":{"status":201}}]}`))
+	}))
+	defer server.Close()
+
+	config = createDefaultConfig()
+	config.WatchFolder = watch
+	config.TailStateFile = filepath.Join(watch, "tail.json")
+	config.CSVOptions.FirstRowHeader = true
+	config.CyberSaucier.Enabled = false
+	config.ElasticSearch.Enabled = true
+	config.ElasticSearch.URL = server.URL
+	config.Profiles = []profileConfig{{Name: "live", FileGlob: "live_*.csv", Tail: &tailConfig{Enabled: true, PollInterval: 1}}}
+	tailOffsets = make(map[string]tailOffset)
+	initES()
+
+	fullpath := filepath.Join(watch, "live_1.csv")
+	appendToFile(t, fullpath, "src,dst\n1,2\n3,4\n")
+	tl := &tailer{fullpath: fullpath, prof: selectProfile(fullpath)}
+
+	//nothing is skipped while ElasticSearch is down
+	assert.True(t, tl.poll())
+	assert.Equal(t, int64(0), tl.offset)
+	assert.Equal(t, 0, tl.line)
+	assert.Nil(t, tl.headers)
+	_, ok := getTailOffset(fullpath)
+	assert.False(t, ok)
+
+	//once it is back the lines are read again, header included
+	down = false
+	assert.True(t, tl.poll())
+	assert.Equal(t, int64(len("src,dst\n1,2\n3,4\n")), tl.offset)
+	assert.Equal(t, 3, tl.line)
+	assert.Equal(t, []string{"src", "dst"}, tl.headers)
+	tl.closeFile(false)
+}
+
+func TestWaitFile_tailRotation(t *testing.T) {
+	watch, err := ioutil.TempDir("", "saucepan_input_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(watch)
+
+	config = createDefaultConfig()
+	config.WatchFolder = watch
+	config.Profiles = []profileConfig{{Name: "live", FileGlob: "live_*.csv*", Tail: &tailConfig{Enabled: true, PollInterval: 1}}}
+
+	fullpath := filepath.Join(watch, "live_1.csv")
+	appendToFile(t, fullpath, "src,dst\n1,2\n")
+	info, err := os.Stat(fullpath)
+	assert.NoError(t, err)
+	tailersLock.Lock()
+	tailers[fullpath] = &tailer{fullpath: fullpath, inode: fileInode(info)}
+	tailersLock.Unlock()
+	defer func() {
+		tailersLock.Lock()
+		delete(tailers, fullpath)
+		tailersLock.Unlock()
+	}()
+
+	//the rotated file matches the tailed profile too, but the tailer already has it open
+	rotated := filepath.Join(watch, "live_1.csv.1")
+	assert.NoError(t, os.Rename(fullpath, rotated))
+	assert.True(t, isTailed(rotated))
+	waitFile(rotated)
+
+	tailersLock.Lock()
+	_, second := tailers[rotated]
+	count := len(tailers)
+	tailersLock.Unlock()
+	assert.False(t, second)
+	assert.Equal(t, 1, count)
+}
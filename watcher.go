@@ -0,0 +1,275 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+//watchBackend - fsnotify, or the polling watcher that stands in for it
+type watchBackend interface {
+	Add(name string) error
+	Remove(name string) error
+	Close() error
+}
+
+//folderWatcher - watches WatchFolder and every folder below it, registering new folders as they are created
+type folderWatcher struct {
+	watcher watchBackend
+	events  <-chan fsnotify.Event
+	errors  <-chan error
+	mode    string
+	lock    sync.Mutex
+	dirs    map[string]bool
+	pending map[string]bool
+	onFile  func(fullpath string)
+}
+
+//newFolderWatcher - creates the watcher for the configured WatchMode; "auto" polls network filesystems
+//and falls back to polling when fsnotify can't be used (e.g. the inotify watch limit is exhausted)
+func newFolderWatcher(root string) (*folderWatcher, error) {
+	switch config.WatchMode {
+	case "poll":
+		return newPollingFolderWatcher(root), nil
+	case "auto":
+		if network, fsType := isNetworkFS(root); network {
+			log.WithFields(log.Fields{"Folder": root, "Filesystem": fsType}).Info("Network filesystem, polling for files")
+			return newPollingFolderWatcher(root), nil
+		}
+		w, err := newNotifyFolderWatcher(root)
+		if err != nil {
+			log.WithError(err).WithField("Folder", root).Warn("Unable to watch folder with fsnotify, polling for files")
+			return newPollingFolderWatcher(root), nil
+		}
+		return w, nil
+	}
+	return newNotifyFolderWatcher(root)
+}
+
+func newBaseFolderWatcher(mode string) *folderWatcher {
+	w := &folderWatcher{
+		mode:    mode,
+		dirs:    make(map[string]bool),
+		pending: make(map[string]bool),
+	}
+	w.onFile = func(fullpath string) {
+		go func() {
+			waitFile(fullpath)
+			w.done(fullpath)
+		}()
+	}
+	return w
+}
+
+func newNotifyFolderWatcher(root string) (*folderWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := newBaseFolderWatcher("fsnotify")
+	w.watcher = watcher
+	w.events = watcher.Events
+	w.errors = watcher.Errors
+
+	if err := w.addDir(root); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	if err := w.addTree(root, false); err != nil && config.WatchMode == "auto" {
+		watcher.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func newPollingFolderWatcher(root string) *folderWatcher {
+	interval := time.Second * time.Duration(config.PollInterval)
+	if interval <= 0 {
+		interval = time.Second
+	}
+	poller := newPollWatcher(root, interval)
+	w := newBaseFolderWatcher("poll")
+	w.watcher = poller
+	w.events = poller.Events
+	w.errors = poller.Errors
+	w.addTree(root, false)
+	return w
+}
+
+//isOutputFolder - true if the folder is the DoneFolder, FailedFolder or QuarantineFolder, which are never
+//watched even when they are inside WatchFolder
+func isOutputFolder(fullpath string) bool {
+	if samePath(fullpath, config.DoneFolder) {
+		return true
+	}
+	return retryEnabled() && (samePath(fullpath, config.FailedFolder) || samePath(fullpath, quarantineFolder()))
+}
+
+func samePath(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	absA, err1 := filepath.Abs(a)
+	absB, err2 := filepath.Abs(b)
+	return err1 == nil && err2 == nil && absA == absB
+}
+
+//isWatchLimitError - true if inotify has run out of watches
+func isWatchLimitError(err error) bool {
+	if se, ok := err.(*os.SyscallError); ok {
+		err = se.Err
+	}
+	return err == syscall.ENOSPC
+}
+
+func (w *folderWatcher) addDir(dir string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.dirs[dir] {
+		return nil
+	}
+	if err := w.watcher.Add(dir); err != nil {
+		if isWatchLimitError(err) {
+			log.WithField("Folder", dir).Warn("Out of inotify watches, raise fs.inotify.max_user_watches or use WatchMode poll")
+		}
+		return err
+	}
+	w.dirs[dir] = true
+	log.WithField("Folder", dir).Debug("Watching Folder")
+	return nil
+}
+
+//removeDir - forgets a deleted folder and everything below it
+func (w *folderWatcher) removeDir(dir string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	prefix := dir + string(filepath.Separator)
+	for watched := range w.dirs {
+		if watched == dir || strings.HasPrefix(watched, prefix) {
+			//the OS has usually dropped the watch already, so errors are expected
+			w.watcher.Remove(watched)
+			delete(w.dirs, watched)
+			log.WithField("Folder", watched).Debug("Stopped Watching Folder")
+		}
+	}
+}
+
+func (w *folderWatcher) isDir(fullpath string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.dirs[fullpath]
+}
+
+//schedule - hands a new file on, unless it is already waiting to be queued
+func (w *folderWatcher) schedule(fullpath string) {
+	w.lock.Lock()
+	if w.pending[fullpath] {
+		w.lock.Unlock()
+		return
+	}
+	w.pending[fullpath] = true
+	noteFileActivity(fullpath)
+	w.lock.Unlock()
+	w.onFile(fullpath)
+}
+
+//noteWrite - records a write to a file that is waiting to be queued; activity for any other file is never
+//read, so it isn't kept
+func (w *folderWatcher) noteWrite(fullpath string) {
+	w.lock.Lock()
+	if w.pending[fullpath] {
+		noteFileActivity(fullpath)
+	}
+	w.lock.Unlock()
+}
+
+func (w *folderWatcher) done(fullpath string) {
+	w.lock.Lock()
+	delete(w.pending, fullpath)
+	forgetFileActivity(fullpath)
+	w.lock.Unlock()
+}
+
+//addTree - watches every folder below dir; for a new folder, files already in it were written before the
+//watch was registered, so they are scheduled too. Returns the first folder that could not be watched
+func (w *folderWatcher) addTree(dir string, scheduleFiles bool) error {
+	var addErr error
+	err := filepath.Walk(dir, func(fullpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.WithError(err).WithField("Path", fullpath).Debug("Error walking new folder")
+			return nil
+		}
+		if info.IsDir() {
+			if isOutputFolder(fullpath) {
+				return filepath.SkipDir
+			}
+			if err := w.addDir(fullpath); err != nil {
+				log.WithError(err).WithField("Folder", fullpath).Warn("Unable to watch folder")
+				if addErr == nil {
+					addErr = err
+				}
+			}
+		} else if scheduleFiles && (isReadinessArtifact(fullpath) || isSelected(fullpath, -1)) {
+			w.schedule(fullpath)
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).WithField("Folder", dir).Warn("Error walking new folder")
+	}
+	return addErr
+}
+
+func (w *folderWatcher) handleEvent(event fsnotify.Event) {
+	if event.Op&fsnotify.Create == fsnotify.Create {
+		info, err := os.Stat(event.Name)
+		if err != nil {
+			return
+		}
+		if info.IsDir() {
+			if !isOutputFolder(event.Name) {
+				w.addTree(event.Name, true)
+			}
+		} else if isReadinessArtifact(event.Name) || isSelected(event.Name, -1) {
+			//markers are let through, a file's name can be checked before it is written, its size once it is ready
+			w.schedule(event.Name)
+		}
+	}
+	if event.Op&fsnotify.Write == fsnotify.Write {
+		w.noteWrite(event.Name)
+	}
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		forgetFileActivity(event.Name)
+		if w.isDir(event.Name) {
+			w.removeDir(event.Name)
+		}
+	}
+}
+
+//run - handles events until the watcher is closed
+func (w *folderWatcher) run() {
+	for {
+		select {
+		case event, ok := <-w.events:
+			if !ok {
+				return
+			}
+			w.handleEvent(event)
+		case err, ok := <-w.errors:
+			if !ok {
+				return
+			}
+			log.WithError(err).Warn("Error during folder watching")
+		}
+	}
+}
+
+func (w *folderWatcher) close() error {
+	return w.watcher.Close()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+//waitForFile - waits for the watcher to hand on the given file
+func waitForFile(t *testing.T, files chan string, expected string) {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case f := <-files:
+			if f == expected {
+				return
+			}
+		case <-timeout:
+			assert.Fail(t, "File was not seen by the watcher", expected)
+			return
+		}
+	}
+}
+
+func TestFolderWatcher_recursive(t *testing.T) {
+	root, err := ioutil.TempDir("", "saucepan_watch_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	config = createDefaultConfig()
+	config.WatchFolder = root
+	config.DoneFolder = filepath.Join(root, "done")
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "existing"), 0755))
+	assert.NoError(t, os.MkdirAll(config.DoneFolder, 0755))
+
+	w, err := newFolderWatcher(root)
+	assert.NoError(t, err)
+	files := make(chan string, 10)
+	w.onFile = func(fullpath string) {
+		files <- fullpath
+		w.done(fullpath)
+	}
+	//wait for the watcher to stop, so it isn't still reading config during the next test
+	stopped := make(chan struct{})
+	go func() {
+		w.run()
+		close(stopped)
+	}()
+	defer func() {
+		w.close()
+		<-stopped
+	}()
+
+	assert.True(t, w.isDir(filepath.Join(root, "existing")))
+	assert.False(t, w.isDir(config.DoneFolder))
+
+	//a file in a folder that existed at startup
+	existing := filepath.Join(root, "existing", "a_1.csv")
+	assert.NoError(t, ioutil.WriteFile(existing, []byte("x\n"), 0644))
+	waitForFile(t, files, existing)
+
+	//a new folder, created with a file already in it, then a file written after
+	newDir := filepath.Join(root, "new", "deeper")
+	assert.NoError(t, os.MkdirAll(newDir, 0755))
+	early := filepath.Join(newDir, "b_1.csv")
+	assert.NoError(t, ioutil.WriteFile(early, []byte("x\n"), 0644))
+	waitForFile(t, files, early)
+
+	late := filepath.Join(newDir, "c_1.csv")
+	assert.NoError(t, ioutil.WriteFile(late, []byte("x\n"), 0644))
+	waitForFile(t, files, late)
+
+	//deleted folders are forgotten
+	assert.NoError(t, os.RemoveAll(filepath.Join(root, "new")))
+	deadline := time.Now().Add(time.Second * 5)
+	for w.isDir(newDir) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.False(t, w.isDir(newDir))
+	assert.False(t, w.isDir(filepath.Join(root, "new")))
+}
+
+func TestFolderWatcher_fileActivity(t *testing.T) {
+	root, err := ioutil.TempDir("", "saucepan_watch_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	config = createDefaultConfig()
+	config.WatchFolder = root
+	w := newBaseFolderWatcher("test")
+	var scheduled []string
+	w.onFile = func(fullpath string) { scheduled = append(scheduled, fullpath) }
+
+	hasActivity := func(fullpath string) bool {
+		_, ok := lastFileActivity(fullpath)
+		return ok
+	}
+
+	//a selected file is tracked while it waits, and forgotten once it is handed on
+	selected := filepath.Join(root, "a_1.csv")
+	assert.NoError(t, ioutil.WriteFile(selected, []byte("x\n"), 0644))
+	w.handleEvent(fsnotify.Event{Name: selected, Op: fsnotify.Create})
+	w.handleEvent(fsnotify.Event{Name: selected, Op: fsnotify.Write})
+	assert.Equal(t, []string{selected}, scheduled)
+	assert.True(t, hasActivity(selected))
+	w.done(selected)
+	assert.False(t, hasActivity(selected))
+
+	//writes after it has been handed on aren't kept
+	w.handleEvent(fsnotify.Event{Name: selected, Op: fsnotify.Write})
+	assert.False(t, hasActivity(selected))
+
+	//nor are writes to files that are never scheduled
+	config.Selection.Exclude = []fileMatcher{{Glob: "*.log"}}
+	ignored := filepath.Join(root, "app.log")
+	assert.NoError(t, ioutil.WriteFile(ignored, []byte("x\n"), 0644))
+	w.handleEvent(fsnotify.Event{Name: ignored, Op: fsnotify.Create})
+	w.handleEvent(fsnotify.Event{Name: ignored, Op: fsnotify.Write})
+	assert.False(t, hasActivity(ignored))
+
+	//a pending file that is renamed away is forgotten
+	second := filepath.Join(root, "b_1.csv")
+	assert.NoError(t, ioutil.WriteFile(second, []byte("x\n"), 0644))
+	w.handleEvent(fsnotify.Event{Name: second, Op: fsnotify.Create})
+	assert.True(t, hasActivity(second))
+	w.handleEvent(fsnotify.Event{Name: second, Op: fsnotify.Rename})
+	assert.False(t, hasActivity(second))
+}